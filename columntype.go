@@ -0,0 +1,225 @@
+package mysql
+
+import (
+	"reflect"
+	"time"
+)
+
+// ColumnMetadata describes a result column. The driver.Rows returned by the
+// driver have a ColumnMetadata(index int) ColumnMetadata method. database/sql
+// does not expose it, so the query must be run on the driver connection
+// itself, e.g. with driver.Queryer inside sql.Conn.Raw.
+type ColumnMetadata struct {
+	Schema   string
+	Table    string // table alias
+	OrgTable string // physical table
+	Name     string // column alias
+	OrgName  string // physical column
+	Type     byte   // one of the MYSQL_TYPE constants
+	Flags    uint16 // NOT_NULL_FLAG, UNSIGNED_FLAG, ENUM_FLAG, ...
+	Charset  uint16 // collation id
+	Length   uint32 // maximum length in bytes
+	Decimals byte
+}
+
+// ColumnMetadata returns the metadata of column index.
+func (r *result) ColumnMetadata(index int) ColumnMetadata {
+	col := &r.columns[index]
+	return ColumnMetadata{
+		Schema:   col.schema,
+		Table:    col.table,
+		OrgTable: col.orgTable,
+		Name:     col.name,
+		OrgName:  col.orgName,
+		Type:     col.coltype,
+		Flags:    col.flags,
+		Charset:  col.charset,
+		Length:   col.length,
+		Decimals: col.decimals,
+	}
+}
+
+// ColumnTypeDatabaseTypeName implements driver.RowsColumnTypeDatabaseTypeName.
+// ENUM and SET columns, which the server sends as CHAR, are told apart by
+// their flags.
+func (r *result) ColumnTypeDatabaseTypeName(index int) string {
+	col := &r.columns[index]
+	name := typeNames[col.coltype]
+	binary := col.charset == CHARSET_BINARY
+	switch col.coltype {
+	case MYSQL_TYPE_STRING:
+		switch {
+		case col.flags&ENUM_FLAG != 0:
+			return "ENUM"
+		case col.flags&SET_FLAG != 0:
+			return "SET"
+		case binary:
+			return "BINARY"
+		}
+	case MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING:
+		if binary {
+			return "VARBINARY"
+		}
+	case MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_BLOB, MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB:
+		if !binary {
+			return textNames[col.coltype]
+		}
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_INT24, MYSQL_TYPE_LONG, MYSQL_TYPE_LONGLONG,
+		MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE, MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+		if col.flags&UNSIGNED_FLAG != 0 {
+			return "UNSIGNED " + name
+		}
+	}
+	return name
+}
+
+var typeNames = map[byte]string{
+	MYSQL_TYPE_DECIMAL:     "DECIMAL",
+	MYSQL_TYPE_TINY:        "TINYINT",
+	MYSQL_TYPE_SHORT:       "SMALLINT",
+	MYSQL_TYPE_LONG:        "INT",
+	MYSQL_TYPE_FLOAT:       "FLOAT",
+	MYSQL_TYPE_DOUBLE:      "DOUBLE",
+	MYSQL_TYPE_NULL:        "NULL",
+	MYSQL_TYPE_TIMESTAMP:   "TIMESTAMP",
+	MYSQL_TYPE_LONGLONG:    "BIGINT",
+	MYSQL_TYPE_INT24:       "MEDIUMINT",
+	MYSQL_TYPE_DATE:        "DATE",
+	MYSQL_TYPE_TIME:        "TIME",
+	MYSQL_TYPE_DATETIME:    "DATETIME",
+	MYSQL_TYPE_YEAR:        "YEAR",
+	MYSQL_TYPE_NEWDATE:     "DATE",
+	MYSQL_TYPE_VARCHAR:     "VARCHAR",
+	MYSQL_TYPE_BIT:         "BIT",
+	MYSQL_TYPE_VECTOR:      "VECTOR",
+	MYSQL_TYPE_JSON:        "JSON",
+	MYSQL_TYPE_NEWDECIMAL:  "DECIMAL",
+	MYSQL_TYPE_ENUM:        "ENUM",
+	MYSQL_TYPE_SET:         "SET",
+	MYSQL_TYPE_TINY_BLOB:   "TINYBLOB",
+	MYSQL_TYPE_MEDIUM_BLOB: "MEDIUMBLOB",
+	MYSQL_TYPE_LONG_BLOB:   "LONGBLOB",
+	MYSQL_TYPE_BLOB:        "BLOB",
+	MYSQL_TYPE_VAR_STRING:  "VARCHAR",
+	MYSQL_TYPE_STRING:      "CHAR",
+	MYSQL_TYPE_GEOMETRY:    "GEOMETRY",
+}
+
+var textNames = map[byte]string{
+	MYSQL_TYPE_TINY_BLOB:   "TINYTEXT",
+	MYSQL_TYPE_MEDIUM_BLOB: "MEDIUMTEXT",
+	MYSQL_TYPE_LONG_BLOB:   "LONGTEXT",
+	MYSQL_TYPE_BLOB:        "TEXT",
+}
+
+// ColumnTypePrecisionScale implements driver.RowsColumnTypePrecisionScale
+// for DECIMAL columns.
+func (r *result) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	col := &r.columns[index]
+	switch col.coltype {
+	case MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL:
+		// length counts the sign, unless unsigned, and the decimal point
+		precision, scale = int64(col.length), int64(col.decimals)
+		if scale > 0 {
+			precision--
+		}
+		if col.flags&UNSIGNED_FLAG == 0 && precision > 0 {
+			precision--
+		}
+		return precision, scale, true
+	}
+	return 0, 0, false
+}
+
+// ColumnTypeNullable implements driver.RowsColumnTypeNullable.
+func (r *result) ColumnTypeNullable(index int) (nullable, ok bool) {
+	return r.columns[index].flags&NOT_NULL_FLAG == 0, true
+}
+
+// ColumnTypeLength implements driver.RowsColumnTypeLength for string and
+// blob columns. The length of text columns is in characters.
+func (r *result) ColumnTypeLength(index int) (length int64, ok bool) {
+	col := &r.columns[index]
+	if !isStringType(col.coltype) && col.coltype != MYSQL_TYPE_JSON {
+		return 0, false
+	}
+	length = int64(col.length)
+	if col.charset != CHARSET_BINARY {
+		if n, ok := charsetMaxLen[collationCharset(collationNames[col.charset])]; ok {
+			length /= n
+		}
+	}
+	return length, true
+}
+
+// charsetMaxLen is the maximum length in bytes of a character of the
+// multibyte character sets.
+var charsetMaxLen = map[string]int64{
+	"big5":    2,
+	"cp932":   2,
+	"eucjpms": 3,
+	"euckr":   2,
+	"gb18030": 4,
+	"gb2312":  2,
+	"gbk":     2,
+	"sjis":    2,
+	"ucs2":    2,
+	"ujis":    3,
+	"utf16":   4,
+	"utf16le": 4,
+	"utf32":   4,
+	"utf8":    3,
+	"utf8mb3": 3,
+	"utf8mb4": 4,
+}
+
+var (
+	scanTypeBytes     = reflect.TypeOf([]byte(nil))
+	scanTypeString    = reflect.TypeOf("")
+	scanTypeInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// scanTypes are the types of non-NULL values of the binary protocol, and of
+// the text protocol for the types it decodes, indexed by unsigned.
+var scanTypes = map[byte][2]reflect.Type{
+	MYSQL_TYPE_TINY:      {reflect.TypeOf(int8(0)), reflect.TypeOf(uint8(0))},
+	MYSQL_TYPE_SHORT:     {reflect.TypeOf(int16(0)), reflect.TypeOf(uint16(0))},
+	MYSQL_TYPE_YEAR:      {reflect.TypeOf(int16(0)), reflect.TypeOf(uint16(0))},
+	MYSQL_TYPE_INT24:     {reflect.TypeOf(int32(0)), reflect.TypeOf(uint32(0))},
+	MYSQL_TYPE_LONG:      {reflect.TypeOf(int32(0)), reflect.TypeOf(uint32(0))},
+	MYSQL_TYPE_LONGLONG:  {reflect.TypeOf(int64(0)), reflect.TypeOf(uint64(0))},
+	MYSQL_TYPE_FLOAT:     {reflect.TypeOf(float32(0)), reflect.TypeOf(float32(0))},
+	MYSQL_TYPE_DOUBLE:    {reflect.TypeOf(float64(0)), reflect.TypeOf(float64(0))},
+	MYSQL_TYPE_TIMESTAMP: {reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Time{})},
+	MYSQL_TYPE_DATETIME:  {reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Time{})},
+	MYSQL_TYPE_DATE:      {reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Time{})},
+	MYSQL_TYPE_NEWDATE:   {reflect.TypeOf(time.Time{}), reflect.TypeOf(time.Time{})},
+	MYSQL_TYPE_TIME:      {reflect.TypeOf(time.Duration(0)), reflect.TypeOf(time.Duration(0))},
+	MYSQL_TYPE_BIT:       {reflect.TypeOf(uint64(0)), reflect.TypeOf(uint64(0))},
+	MYSQL_TYPE_VECTOR:    {reflect.TypeOf([]float32(nil)), reflect.TypeOf([]float32(nil))},
+}
+
+// ColumnTypeScanType implements driver.RowsColumnTypeScanType. It returns
+// the type of the values Next returns for the column, apart from NULL.
+func (r *result) ColumnTypeScanType(index int) reflect.Type {
+	col := &r.columns[index]
+	switch col.coltype {
+	case MYSQL_TYPE_NULL:
+		return scanTypeInterface
+	case MYSQL_TYPE_TINY, MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR, MYSQL_TYPE_INT24,
+		MYSQL_TYPE_LONG, MYSQL_TYPE_LONGLONG, MYSQL_TYPE_FLOAT, MYSQL_TYPE_DOUBLE:
+		if !r.binary && (r.cn == nil || !r.cn.cfg.TypedText) {
+			return scanTypeBytes
+		}
+	}
+	if t, ok := scanTypes[col.coltype]; ok {
+		if col.flags&UNSIGNED_FLAG != 0 {
+			return t[1]
+		}
+		return t[0]
+	}
+	if r.cn != nil && r.cn.cfg.DecodeCharset && col.charset != CHARSET_BINARY && isStringType(col.coltype) {
+		return scanTypeString
+	}
+	return scanTypeBytes
+}
@@ -73,6 +73,8 @@ const (
 	MYSQL_TYPE_NEWDATE     = 14
 	MYSQL_TYPE_VARCHAR     = 15
 	MYSQL_TYPE_BIT         = 16
+	MYSQL_TYPE_VECTOR      = 242
+	MYSQL_TYPE_JSON        = 245
 	MYSQL_TYPE_NEWDECIMAL  = 246
 	MYSQL_TYPE_ENUM        = 247
 	MYSQL_TYPE_SET         = 248
@@ -83,6 +85,8 @@ const (
 	MYSQL_TYPE_VAR_STRING  = 253
 	MYSQL_TYPE_STRING      = 254
 	MYSQL_TYPE_GEOMETRY    = 255
+
+	UNSIGNED_PARAM = 0x8000 // flag in the type of an unsigned parameter
 )
 
 const (
@@ -121,10 +125,13 @@ const (
 const (
 	MAX_PACKET_SIZE = 1<<24 - 1
 	MAX_DATA_CHUNK  = 1 << 19
+	MAX_READ_BUFFER = 1 << 20 // largest row buffer kept for reuse
+	MAX_POOL_BUFFER = 1 << 20 // largest packet buffer returned to the pool
 )
 
 const (
 	CHARSET_UTF8    = 33
+	CHARSET_BINARY  = 63
 	CHARSET_UTF8MB4 = 45
 )
 
@@ -134,3 +141,10 @@ const (
 	LOCAL_INFILE = 0xfb
 	ERR          = 0xff
 )
+
+// from /usr/include/mysql/mysqld_error.h
+const (
+	ER_PARSE_ERROR               = 1064
+	ER_UNKNOWN_SYSTEM_VARIABLE   = 1193
+	ER_OPTION_PREVENTS_STATEMENT = 1290
+)
@@ -0,0 +1,651 @@
+// Package geometry implements the MySQL spatial types.
+//
+// Geometries are read from and written to MySQL's internal format, a 4 byte
+// SRID followed by the WKB (well-known binary) representation, and can be
+// rendered as WKT (well-known text) and GeoJSON. The types implement
+// sql.Scanner and driver.Valuer, so they can be used as scan targets and
+// arguments directly. They have SRID 0; Any carries the SRID of a geometry,
+// and the other types refuse to scan a geometry with a non-zero SRID rather
+// than drop it.
+package geometry
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// Geometry is one of Point, LineString, Polygon, MultiPoint,
+// MultiLineString, MultiPolygon and GeometryCollection.
+type Geometry interface {
+	// Type returns the WKT name of the type, e.g. "POLYGON".
+	Type() string
+
+	wkbType() uint32
+	appendWKB(b []byte) []byte
+	appendWKT(b []byte) []byte // without the type name
+	coordinates() interface{}  // GeoJSON coordinates
+}
+
+// Point is a single location, in the units of the SRID: X is the longitude
+// and Y the latitude for a geographic SRID.
+type Point struct {
+	X, Y float64
+}
+
+// LineString is a curve through two or more points.
+type LineString []Point
+
+// Polygon is an outer ring followed by any inner rings. Rings are closed:
+// the last point equals the first.
+type Polygon []LineString
+
+// MultiPoint is a set of points.
+type MultiPoint []Point
+
+// MultiLineString is a set of line strings.
+type MultiLineString []LineString
+
+// MultiPolygon is a set of polygons.
+type MultiPolygon []Polygon
+
+// GeometryCollection is a set of geometries of any type.
+type GeometryCollection []Geometry
+
+// WKB geometry types.
+const (
+	wkbPoint = iota + 1
+	wkbLineString
+	wkbPolygon
+	wkbMultiPoint
+	wkbMultiLineString
+	wkbMultiPolygon
+	wkbGeometryCollection
+)
+
+func (Point) Type() string              { return "POINT" }
+func (LineString) Type() string         { return "LINESTRING" }
+func (Polygon) Type() string            { return "POLYGON" }
+func (MultiPoint) Type() string         { return "MULTIPOINT" }
+func (MultiLineString) Type() string    { return "MULTILINESTRING" }
+func (MultiPolygon) Type() string       { return "MULTIPOLYGON" }
+func (GeometryCollection) Type() string { return "GEOMETRYCOLLECTION" }
+
+func (Point) wkbType() uint32              { return wkbPoint }
+func (LineString) wkbType() uint32         { return wkbLineString }
+func (Polygon) wkbType() uint32            { return wkbPolygon }
+func (MultiPoint) wkbType() uint32         { return wkbMultiPoint }
+func (MultiLineString) wkbType() uint32    { return wkbMultiLineString }
+func (MultiPolygon) wkbType() uint32       { return wkbMultiPolygon }
+func (GeometryCollection) wkbType() uint32 { return wkbGeometryCollection }
+
+// Marshal returns g with srid in MySQL's internal format.
+func Marshal(srid uint32, g Geometry) ([]byte, error) {
+	return WKB(appendUint32(nil, srid), g)
+}
+
+// Unmarshal parses a geometry in MySQL's internal format.
+func Unmarshal(b []byte) (srid uint32, g Geometry, err error) {
+	if len(b) < 4 {
+		return 0, nil, fmt.Errorf("geometry: too short")
+	}
+	g, err = ParseWKB(b[4:])
+	return binary.LittleEndian.Uint32(b), g, err
+}
+
+// WKB appends the little-endian WKB representation of g to b.
+func WKB(b []byte, g Geometry) ([]byte, error) {
+	if err := checkNil(g); err != nil {
+		return nil, err
+	}
+	return appendGeometry(b, g), nil
+}
+
+// checkNil returns an error if g is or contains a nil geometry, which has no
+// representation.
+func checkNil(g Geometry) error {
+	if v := reflect.ValueOf(g); !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		return fmt.Errorf("geometry: nil geometry")
+	}
+	if c, ok := g.(GeometryCollection); ok {
+		for _, g := range c {
+			if err := checkNil(g); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func appendGeometry(b []byte, g Geometry) []byte {
+	b = append(b, 1) // little-endian
+	return g.appendWKB(appendUint32(b, g.wkbType()))
+}
+
+// ParseWKB parses the WKB representation of a geometry.
+func ParseWKB(b []byte) (Geometry, error) {
+	r := &wkbReader{b: b}
+	g := r.geometry(0)
+	if r.err == nil && len(r.b) > 0 {
+		r.err = fmt.Errorf("geometry: %d trailing bytes", len(r.b))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return g, nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendFloat(b []byte, f float64) []byte {
+	v := math.Float64bits(f)
+	return appendUint32(appendUint32(b, uint32(v)), uint32(v>>32))
+}
+
+func appendCount(b []byte, n int) []byte {
+	return appendUint32(b, uint32(n))
+}
+
+func (p Point) appendWKB(b []byte) []byte {
+	return appendFloat(appendFloat(b, p.X), p.Y)
+}
+
+func (l LineString) appendWKB(b []byte) []byte {
+	b = appendCount(b, len(l))
+	for _, p := range l {
+		b = p.appendWKB(b)
+	}
+	return b
+}
+
+func (p Polygon) appendWKB(b []byte) []byte {
+	b = appendCount(b, len(p))
+	for _, l := range p {
+		b = l.appendWKB(b)
+	}
+	return b
+}
+
+func (m MultiPoint) appendWKB(b []byte) []byte {
+	b = appendCount(b, len(m))
+	for _, p := range m {
+		b = appendGeometry(b, p)
+	}
+	return b
+}
+
+func (m MultiLineString) appendWKB(b []byte) []byte {
+	b = appendCount(b, len(m))
+	for _, l := range m {
+		b = appendGeometry(b, l)
+	}
+	return b
+}
+
+func (m MultiPolygon) appendWKB(b []byte) []byte {
+	b = appendCount(b, len(m))
+	for _, p := range m {
+		b = appendGeometry(b, p)
+	}
+	return b
+}
+
+func (c GeometryCollection) appendWKB(b []byte) []byte {
+	b = appendCount(b, len(c))
+	for _, g := range c {
+		b = appendGeometry(b, g)
+	}
+	return b
+}
+
+// maxDepth limits the nesting of geometry collections.
+const maxDepth = 32
+
+// wkbReader reads WKB, remembering the first error.
+type wkbReader struct {
+	b     []byte
+	order binary.ByteOrder
+	err   error
+}
+
+func (r *wkbReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if len(r.b) < n {
+		r.err = fmt.Errorf("geometry: too short")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *wkbReader) uint32() uint32 {
+	if b := r.next(4); b != nil {
+		return r.order.Uint32(b)
+	}
+	return 0
+}
+
+func (r *wkbReader) float() float64 {
+	if b := r.next(8); b != nil {
+		return math.Float64frombits(r.order.Uint64(b))
+	}
+	return 0
+}
+
+// count reads the number of elements of at least size bytes each.
+func (r *wkbReader) count(size int) int {
+	n := int(r.uint32())
+	if r.err == nil && n > len(r.b)/size {
+		r.err = fmt.Errorf("geometry: invalid count %d", n)
+		return 0
+	}
+	return n
+}
+
+// header reads the byte order and type of a geometry.
+func (r *wkbReader) header() uint32 {
+	b := r.next(1)
+	if b == nil {
+		return 0
+	}
+	switch b[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		r.err = fmt.Errorf("geometry: invalid byte order %d", b[0])
+		return 0
+	}
+	return r.uint32()
+}
+
+func (r *wkbReader) geometry(depth int) Geometry {
+	switch t := r.header(); t {
+	case wkbPoint:
+		return r.point()
+	case wkbLineString:
+		return r.lineString()
+	case wkbPolygon:
+		return r.polygon()
+	case wkbMultiPoint:
+		m := make(MultiPoint, r.count(21))
+		for i := range m {
+			if r.expect(wkbPoint) {
+				m[i] = r.point()
+			}
+		}
+		return m
+	case wkbMultiLineString:
+		m := make(MultiLineString, r.count(9))
+		for i := range m {
+			if r.expect(wkbLineString) {
+				m[i] = r.lineString()
+			}
+		}
+		return m
+	case wkbMultiPolygon:
+		m := make(MultiPolygon, r.count(9))
+		for i := range m {
+			if r.expect(wkbPolygon) {
+				m[i] = r.polygon()
+			}
+		}
+		return m
+	case wkbGeometryCollection:
+		if depth >= maxDepth {
+			r.err = fmt.Errorf("geometry: collections nested too deeply")
+			return nil
+		}
+		c := make(GeometryCollection, r.count(9))
+		for i := range c {
+			c[i] = r.geometry(depth + 1)
+		}
+		return c
+	default:
+		if r.err == nil {
+			r.err = fmt.Errorf("geometry: unknown type %d", t)
+		}
+		return nil
+	}
+}
+
+// expect reads the header of an element of a multi geometry and reports
+// whether it is of type t.
+func (r *wkbReader) expect(t uint32) bool {
+	if got := r.header(); r.err == nil && got != t {
+		r.err = fmt.Errorf("geometry: unexpected type %d, want %d", got, t)
+	}
+	return r.err == nil
+}
+
+func (r *wkbReader) point() Point {
+	return Point{r.float(), r.float()}
+}
+
+func (r *wkbReader) lineString() LineString {
+	l := make(LineString, r.count(16))
+	for i := range l {
+		l[i] = r.point()
+	}
+	return l
+}
+
+func (r *wkbReader) polygon() Polygon {
+	p := make(Polygon, r.count(4))
+	for i := range p {
+		p[i] = r.lineString()
+	}
+	return p
+}
+
+// WKT returns the WKT representation of g.
+func WKT(g Geometry) string {
+	if v := reflect.ValueOf(g); v.Kind() == reflect.Slice && v.Len() == 0 {
+		return g.Type() + " EMPTY"
+	}
+	return string(g.appendWKT([]byte(g.Type())))
+}
+
+func (p Point) appendWKT(b []byte) []byte {
+	b = append(b, '(')
+	b = p.appendCoords(b)
+	return append(b, ')')
+}
+
+func (p Point) appendCoords(b []byte) []byte {
+	b = strconv.AppendFloat(b, p.X, 'f', -1, 64)
+	b = append(b, ' ')
+	return strconv.AppendFloat(b, p.Y, 'f', -1, 64)
+}
+
+func (l LineString) appendWKT(b []byte) []byte {
+	b = append(b, '(')
+	for i, p := range l {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = p.appendCoords(b)
+	}
+	return append(b, ')')
+}
+
+func (p Polygon) appendWKT(b []byte) []byte {
+	b = append(b, '(')
+	for i, l := range p {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = l.appendWKT(b)
+	}
+	return append(b, ')')
+}
+
+func (m MultiPoint) appendWKT(b []byte) []byte {
+	b = append(b, '(')
+	for i, p := range m {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = p.appendWKT(b)
+	}
+	return append(b, ')')
+}
+
+func (m MultiLineString) appendWKT(b []byte) []byte {
+	return Polygon(m).appendWKT(b)
+}
+
+func (m MultiPolygon) appendWKT(b []byte) []byte {
+	b = append(b, '(')
+	for i, p := range m {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = p.appendWKT(b)
+	}
+	return append(b, ')')
+}
+
+func (c GeometryCollection) appendWKT(b []byte) []byte {
+	b = append(b, '(')
+	for i, g := range c {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = append(b, WKT(g)...)
+	}
+	return append(b, ')')
+}
+
+func (p Point) String() string              { return WKT(p) }
+func (l LineString) String() string         { return WKT(l) }
+func (p Polygon) String() string            { return WKT(p) }
+func (m MultiPoint) String() string         { return WKT(m) }
+func (m MultiLineString) String() string    { return WKT(m) }
+func (m MultiPolygon) String() string       { return WKT(m) }
+func (c GeometryCollection) String() string { return WKT(c) }
+
+// geoJSONTypes are the GeoJSON names of the WKB types.
+var geoJSONTypes = [...]string{
+	wkbPoint:              "Point",
+	wkbLineString:         "LineString",
+	wkbPolygon:            "Polygon",
+	wkbMultiPoint:         "MultiPoint",
+	wkbMultiLineString:    "MultiLineString",
+	wkbMultiPolygon:       "MultiPolygon",
+	wkbGeometryCollection: "GeometryCollection",
+}
+
+type geoJSON struct {
+	Type        string        `json:"type"`
+	Coordinates interface{}   `json:"coordinates,omitempty"`
+	Geometries  []interface{} `json:"geometries,omitempty"`
+}
+
+func toGeoJSON(g Geometry) interface{} {
+	j := geoJSON{Type: geoJSONTypes[g.wkbType()]}
+	if c, ok := g.(GeometryCollection); ok {
+		j.Geometries = make([]interface{}, len(c))
+		for i, g := range c {
+			j.Geometries[i] = toGeoJSON(g)
+		}
+		return j
+	}
+	j.Coordinates = g.coordinates()
+	return j
+}
+
+// GeoJSON returns the GeoJSON representation of g.
+func GeoJSON(g Geometry) ([]byte, error) {
+	if err := checkNil(g); err != nil {
+		return nil, err
+	}
+	return json.Marshal(toGeoJSON(g))
+}
+
+func (p Point) coordinates() interface{} {
+	return [2]float64{p.X, p.Y}
+}
+
+func (l LineString) coordinates() interface{} {
+	c := make([]interface{}, len(l))
+	for i, p := range l {
+		c[i] = p.coordinates()
+	}
+	return c
+}
+
+func (p Polygon) coordinates() interface{} {
+	c := make([]interface{}, len(p))
+	for i, l := range p {
+		c[i] = l.coordinates()
+	}
+	return c
+}
+
+func (m MultiPoint) coordinates() interface{} {
+	return LineString(m).coordinates()
+}
+
+func (m MultiLineString) coordinates() interface{} {
+	return Polygon(m).coordinates()
+}
+
+func (m MultiPolygon) coordinates() interface{} {
+	c := make([]interface{}, len(m))
+	for i, p := range m {
+		c[i] = p.coordinates()
+	}
+	return c
+}
+
+func (c GeometryCollection) coordinates() interface{} {
+	return nil
+}
+
+// Any is a geometry of any type with its SRID. It implements sql.Scanner
+// and driver.Valuer; a nil Geometry is NULL.
+type Any struct {
+	SRID     uint32
+	Geometry Geometry
+}
+
+// Scan implements the sql.Scanner interface.
+func (a *Any) Scan(value interface{}) (err error) {
+	switch v := value.(type) {
+	case nil:
+		a.SRID, a.Geometry = 0, nil
+		return nil
+	case []byte:
+		a.SRID, a.Geometry, err = Unmarshal(v)
+		return err
+	}
+	return fmt.Errorf("geometry: cannot scan %T", value)
+}
+
+// Value implements the driver.Valuer interface.
+func (a Any) Value() (driver.Value, error) {
+	if a.Geometry == nil {
+		return nil, nil
+	}
+	return Marshal(a.SRID, a.Geometry)
+}
+
+// scan parses value into dst, which must be a geometry of the same type.
+func scan(dst Geometry, value interface{}) (Geometry, error) {
+	var a Any
+	if err := a.Scan(value); err != nil {
+		return nil, err
+	}
+	if a.Geometry == nil {
+		return nil, fmt.Errorf("geometry: cannot scan NULL into %s, use Any", dst.Type())
+	}
+	if a.SRID != 0 {
+		return nil, fmt.Errorf("geometry: cannot scan SRID %d into %s, use Any", a.SRID, dst.Type())
+	}
+	if a.Geometry.wkbType() != dst.wkbType() {
+		return nil, fmt.Errorf("geometry: cannot scan %s into %s", a.Geometry.Type(), dst.Type())
+	}
+	return a.Geometry, nil
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *Point) Scan(value interface{}) error {
+	g, err := scan(*p, value)
+	if err == nil {
+		*p = g.(Point)
+	}
+	return err
+}
+
+// Scan implements the sql.Scanner interface.
+func (l *LineString) Scan(value interface{}) error {
+	g, err := scan(*l, value)
+	if err == nil {
+		*l = g.(LineString)
+	}
+	return err
+}
+
+// Scan implements the sql.Scanner interface.
+func (p *Polygon) Scan(value interface{}) error {
+	g, err := scan(*p, value)
+	if err == nil {
+		*p = g.(Polygon)
+	}
+	return err
+}
+
+// Scan implements the sql.Scanner interface.
+func (m *MultiPoint) Scan(value interface{}) error {
+	g, err := scan(*m, value)
+	if err == nil {
+		*m = g.(MultiPoint)
+	}
+	return err
+}
+
+// Scan implements the sql.Scanner interface.
+func (m *MultiLineString) Scan(value interface{}) error {
+	g, err := scan(*m, value)
+	if err == nil {
+		*m = g.(MultiLineString)
+	}
+	return err
+}
+
+// Scan implements the sql.Scanner interface.
+func (m *MultiPolygon) Scan(value interface{}) error {
+	g, err := scan(*m, value)
+	if err == nil {
+		*m = g.(MultiPolygon)
+	}
+	return err
+}
+
+// Scan implements the sql.Scanner interface.
+func (c *GeometryCollection) Scan(value interface{}) error {
+	g, err := scan(*c, value)
+	if err == nil {
+		*c = g.(GeometryCollection)
+	}
+	return err
+}
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (p Point) Value() (driver.Value, error) { return Marshal(0, p) }
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (l LineString) Value() (driver.Value, error) { return Marshal(0, l) }
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (p Polygon) Value() (driver.Value, error) { return Marshal(0, p) }
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (m MultiPoint) Value() (driver.Value, error) { return Marshal(0, m) }
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (m MultiLineString) Value() (driver.Value, error) { return Marshal(0, m) }
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (m MultiPolygon) Value() (driver.Value, error) { return Marshal(0, m) }
+
+// Value implements the driver.Valuer interface, with SRID 0; use Any for
+// another SRID.
+func (c GeometryCollection) Value() (driver.Value, error) { return Marshal(0, c) }
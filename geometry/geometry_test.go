@@ -0,0 +1,154 @@
+package geometry
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+var square = LineString{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+var hole = LineString{{2, 2}, {4, 2}, {4, 4}, {2, 2}}
+
+var geometryTests = []struct {
+	g       Geometry
+	wkt     string
+	geojson string
+}{
+	{Point{1.5, -2}, "POINT(1.5 -2)", `{"type":"Point","coordinates":[1.5,-2]}`},
+	{LineString{{0, 0}, {1, 1}}, "LINESTRING(0 0,1 1)", `{"type":"LineString","coordinates":[[0,0],[1,1]]}`},
+	{Polygon{square, hole}, "POLYGON((0 0,10 0,10 10,0 10,0 0),(2 2,4 2,4 4,2 2))",
+		`{"type":"Polygon","coordinates":[[[0,0],[10,0],[10,10],[0,10],[0,0]],[[2,2],[4,2],[4,4],[2,2]]]}`},
+	{MultiPoint{{0, 0}, {1, 2}}, "MULTIPOINT((0 0),(1 2))", `{"type":"MultiPoint","coordinates":[[0,0],[1,2]]}`},
+	{MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}}}, "MULTILINESTRING((0 0,1 1),(2 2,3 3))",
+		`{"type":"MultiLineString","coordinates":[[[0,0],[1,1]],[[2,2],[3,3]]]}`},
+	{MultiPolygon{{hole}, {square}}, "MULTIPOLYGON(((2 2,4 2,4 4,2 2)),((0 0,10 0,10 10,0 10,0 0)))",
+		`{"type":"MultiPolygon","coordinates":[[[[2,2],[4,2],[4,4],[2,2]]],[[[0,0],[10,0],[10,10],[0,10],[0,0]]]]}`},
+	{GeometryCollection{Point{1, 2}, GeometryCollection{LineString{{0, 0}, {1, 1}}}}, "GEOMETRYCOLLECTION(POINT(1 2),GEOMETRYCOLLECTION(LINESTRING(0 0,1 1)))",
+		`{"type":"GeometryCollection","geometries":[{"type":"Point","coordinates":[1,2]},{"type":"GeometryCollection","geometries":[{"type":"LineString","coordinates":[[0,0],[1,1]]}]}]}`},
+	{GeometryCollection{}, "GEOMETRYCOLLECTION EMPTY", `{"type":"GeometryCollection"}`},
+}
+
+func TestGeometry(t *testing.T) {
+	for _, tt := range geometryTests {
+		if got := WKT(tt.g); got != tt.wkt {
+			t.Errorf("got %s, want %s", got, tt.wkt)
+		}
+		if got, err := GeoJSON(tt.g); err != nil || string(got) != tt.geojson {
+			t.Errorf("%s: got %s, %v, want %s", tt.wkt, got, err, tt.geojson)
+		}
+
+		b, err := Marshal(4326, tt.g)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.wkt, err)
+		}
+		srid, g, err := Unmarshal(b)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.wkt, err)
+		}
+		if srid != 4326 || !reflect.DeepEqual(g, tt.g) {
+			t.Errorf("got %d %v, want 4326 %v", srid, g, tt.g)
+		}
+
+		for n := 0; n < len(b); n++ {
+			if _, _, err := Unmarshal(b[:n]); err == nil {
+				t.Errorf("%s: no error for %d of %d bytes", tt.wkt, n, len(b))
+			}
+		}
+	}
+}
+
+func TestMySQLFormat(t *testing.T) {
+	// SELECT HEX(ST_GeomFromText('POINT(1 2)', 4326))
+	b, _ := hex.DecodeString("E6100000" + "01" + "01000000" + "000000000000F03F" + "0000000000000040")
+	var a Any
+	if err := a.Scan(b); err != nil {
+		t.Fatal(err)
+	}
+	if a.SRID != 4326 || a.Geometry != (Point{1, 2}) {
+		t.Errorf("got %+v", a)
+	}
+	if v, err := a.Value(); err != nil || !bytes.Equal(v.([]byte), b) {
+		t.Errorf("got %x, %v, want %x", v, err, b)
+	}
+
+	// big-endian WKB
+	be, _ := hex.DecodeString("00000000" + "00" + "00000001" + "3FF0000000000000" + "4000000000000000")
+	var p Point
+	if err := p.Scan(be); err != nil {
+		t.Fatal(err)
+	}
+	if p != (Point{1, 2}) {
+		t.Errorf("got %v", p)
+	}
+}
+
+// marshal is Marshal of a geometry known to have no nil elements.
+func marshal(srid uint32, g Geometry) []byte {
+	b, _ := Marshal(srid, g)
+	return b
+}
+
+func TestScan(t *testing.T) {
+	var zone Polygon
+	if err := zone.Scan(marshal(0, Polygon{square})); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(zone, Polygon{square}) {
+		t.Errorf("got %v", zone)
+	}
+	if err := zone.Scan(marshal(0, Point{})); err == nil {
+		t.Errorf("expected error scanning POINT into POLYGON")
+	}
+	if err := zone.Scan(nil); err == nil {
+		t.Errorf("expected error scanning NULL into POLYGON")
+	}
+	if err := zone.Scan(marshal(4326, Polygon{square})); err == nil {
+		t.Errorf("expected error scanning SRID 4326 into POLYGON")
+	}
+
+	var a Any
+	if err := a.Scan(nil); err != nil || a.Geometry != nil {
+		t.Errorf("got %v, %v", a, err)
+	}
+	if v, err := a.Value(); v != nil || err != nil {
+		t.Errorf("got %v, %v", v, err)
+	}
+
+	var _ driver.Valuer = zone
+	if v, err := zone.Value(); err != nil || !bytes.Equal(v.([]byte), marshal(0, zone)) {
+		t.Errorf("got %x, %v", v, err)
+	}
+}
+
+func TestNesting(t *testing.T) {
+	var g Geometry = Point{}
+	for i := 0; i < maxDepth+1; i++ {
+		g = GeometryCollection{g}
+	}
+	b, err := WKB(nil, g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseWKB(b); err == nil {
+		t.Errorf("expected error for deep nesting")
+	}
+}
+
+func TestNil(t *testing.T) {
+	for _, g := range []Geometry{nil, GeometryCollection{Point{}, nil}, GeometryCollection{GeometryCollection{nil}}, (*Point)(nil)} {
+		if _, err := WKB(nil, g); err == nil {
+			t.Errorf("%#v: expected error", g)
+		}
+		if _, err := GeoJSON(g); err == nil {
+			t.Errorf("%#v: expected error for GeoJSON", g)
+		}
+	}
+	if _, err := (GeometryCollection{nil}).Value(); err == nil {
+		t.Errorf("expected error for a nil element")
+	}
+	if _, err := (Any{4326, GeometryCollection{nil}}).Value(); err == nil {
+		t.Errorf("expected error for a nil element in Any")
+	}
+}
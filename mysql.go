@@ -7,6 +7,7 @@ package mysql
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha1"
 	"crypto/tls"
 	"database/sql"
@@ -14,11 +15,15 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math/big"
 	"net"
-	"net/url"
 	"os"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type mysql struct{}
@@ -31,20 +36,16 @@ type conn struct {
 	serverCapabilities uint16
 	serverLanguage     uint8
 	serverStatus       uint16
-	host               string
-	port               int
-	user               string
-	password           *string
-	db                 string
+	cfg                *Config
+	addr               string
 	netconn            net.Conn
 	bufrd              *bufio.Reader
-	tls                *tls.Config
-	socket             string
-	strict             bool
-	debug              bool
-	allowLocalInfile   bool
-	charset            string
+	bufwr              *bufio.Writer
+	broken             bool
 	seq                byte
+	enc                Encoding  // connection character set when decoding strings
+	rbuf               []byte    // reused for row packets
+	deadline           time.Time // of the connection setup, zero after it
 }
 
 type stmt struct {
@@ -57,7 +58,11 @@ type stmt struct {
 }
 
 type column struct {
+	schema   string
+	table    string
+	orgTable string
 	name     string
+	orgName  string
 	charset  uint16
 	length   uint32
 	coltype  byte
@@ -65,6 +70,44 @@ type column struct {
 	decimals byte
 }
 
+// Error is an error reported by the MySQL server.
+type Error struct {
+	Code    uint16
+	State   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.State, e.Message)
+}
+
+// TimeoutError is returned when dialing, the handshake or a read or write on
+// the connection exceeds the timeout configured in the DSN.
+type TimeoutError struct {
+	Op  string // "dial", "connect", "read" or "write"
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s timeout: %v", e.Op, e.Err)
+}
+
+func (e *TimeoutError) Timeout() bool { return true }
+
+// Temporary is false: a read or write timeout leaves the connection broken,
+// so retrying on it cannot succeed.
+func (e *TimeoutError) Temporary() bool { return false }
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// timeoutError wraps err in a TimeoutError if it is a network timeout.
+func timeoutError(op string, err error) error {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return &TimeoutError{Op: op, Err: err}
+	}
+	return err
+}
+
 type result struct {
 	cn           *conn
 	columns      []column
@@ -81,7 +124,11 @@ func init() {
 }
 
 func (d *mysql) Open(name string) (driver.Conn, error) {
-	conn, err := connect(name)
+	cfg, err := ParseDSN(name)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := connect(context.Background(), cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -89,93 +136,209 @@ func (d *mysql) Open(name string) (driver.Conn, error) {
 	return conn, nil
 }
 
-func connect(dsn string) (*conn, error) {
-	u, err := url.Parse(dsn)
+func (d *mysql) OpenConnector(name string) (driver.Connector, error) {
+	cfg, err := ParseDSN(name)
 	if err != nil {
-		return nil, fmt.Errorf("invalid dsn: %s", dsn)
-	}
-
-	cn := &conn{host: "localhost", port: 3306, user: "root", socket: "/var/run/mysqld/mysqld.sock"}
-
-	switch u.Scheme {
-	case "mysql":
-	case "mysqls":
-		cn.tls = &tls.Config{}
-	default:
-		return nil, fmt.Errorf("invalid scheme: %s", dsn)
+		return nil, err
 	}
+	return NewConnector(cfg)
+}
 
-	for k, v := range u.Query() {
-		switch k {
-		case "debug":
-			cn.debug = true
-		case "ssl-insecure-skip-verify":
-			if cn.tls != nil {
-				cn.tls.InsecureSkipVerify = true
-			}
-		case "allow-insecure-local-infile":
-			cn.allowLocalInfile = true
-		case "charset":
-			cn.charset = v[0]
-		case "socket":
-			cn.socket = v[0]
-		case "strict":
-			cn.strict = true
-		default:
-			return nil, fmt.Errorf("invalid parameter: %s", k)
+// connect connects to the first of the configured addresses that accepts
+// the connection and, if required, is a writable primary.
+func connect(ctx context.Context, cfg *Config) (cn *conn, err error) {
+	addrs := cfg.addrs()
+	if cfg.SRV != "" {
+		if addrs, err = cfg.lookupSRV(ctx); err != nil {
+			return nil, err
 		}
 	}
-
-	if len(u.Host) > 0 {
-		host_port := strings.SplitN(u.Host, ":", 2)
-		cn.host = host_port[0]
-
-		if len(host_port) == 2 {
-			cn.port, err = strconv.Atoi(host_port[1])
-			if err != nil {
-				return nil, fmt.Errorf("invalid port: %s", dsn)
-			}
+	for _, addr := range addrs {
+		if cn, err = connectAddr(ctx, cfg, addr); err == nil {
+			return cn, nil
+		}
+		if cfg.Debug {
+			log.Printf("connect: %s: %v\n", addr, err)
+		}
+		if ctx.Err() != nil {
+			break
 		}
 	}
+	return nil, err
+}
 
-	if u.User != nil {
-		cn.user = u.User.Username()
-		if p, ok := u.User.Password(); ok {
-			cn.password = &p
+func connectAddr(ctx context.Context, cfg *Config, addr string) (cn *conn, err error) {
+	cn = &conn{cfg: cfg, addr: addr}
+	if cfg.DecodeCharset {
+		if cn.enc, err = getEncoding(cfg.connCharset()); err != nil {
+			return nil, err
 		}
 	}
 
-	if len(u.Path) > 0 {
-		path := strings.SplitN(u.Path, "/", 2)
-		cn.db = path[1]
+	if cn.netconn, err = cfg.dial(ctx, addr); err != nil {
+		return nil, timeoutError("dial", err)
 	}
 
-	if u.Host == "(unix)" {
-		cn.netconn, err = net.Dial("unix", cn.socket)
-	} else {
-		cn.netconn, err = net.Dial("tcp", fmt.Sprintf("%s:%d", cn.host, cn.port))
+	var deadline time.Time
+	if cfg.Timeout > 0 {
+		deadline = time.Now().Add(cfg.Timeout)
 	}
-	if err != nil {
-		return nil, err
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	if !deadline.IsZero() {
+		cn.netconn.SetDeadline(deadline)
+		cn.deadline = deadline
+	}
+	if cfg.ProxyProtocol != 0 {
+		if err = cn.writeProxyHeader(); err != nil {
+			cn.netconn.Close()
+			return nil, timeoutError("connect", err)
+		}
 	}
 	if err = cn.hello(); err != nil {
 		cn.netconn.Close()
-		return nil, err
+		return nil, timeoutError("connect", err)
 	}
 
 	cn.bufrd = bufio.NewReader(cn.netconn)
+	cn.bufwr = bufio.NewWriter(cn.netconn)
 
-	if cn.debug {
-		log.Printf("connected: %s #%d (%s)\n", dsn, cn.connId, cn.serverVersion)
+	if cfg.Debug {
+		log.Printf("connected: %s %s #%d (%s)\n", cfg.Net, addr, cn.connId, cn.serverVersion)
+		if s, ok := cn.TLSConnectionState(); ok {
+			log.Printf("tls: version %#04x, cipher suite %#04x, resumed %v\n", s.Version, s.CipherSuite, s.DidResume)
+		}
 	}
-	if cn.charset != "" {
-		if _, err := cn.Exec("SET NAMES "+cn.charset, nil); err != nil {
+	if cfg.RequirePrimary {
+		if err = cn.checkPrimary(); err != nil {
+			cn.netconn.Close()
 			return nil, err
 		}
 	}
+	if err = cn.initSession(ctx); err != nil {
+		cn.netconn.Close()
+		return nil, err
+	}
+	// the timeout covers the session setup; from here on only the read and
+	// write timeouts apply
+	if !deadline.IsZero() {
+		cn.deadline = time.Time{}
+		cn.netconn.SetDeadline(time.Time{})
+	}
 	return cn, nil
 }
 
+// initSession sets the connection character set, session variables and, with
+// SyncTimeZone, time_zone in a single SET statement and then runs the OnConnect hook. The collation is
+// normally chosen in the handshake, but ids above 255 do not fit there.
+func (cn *conn) initSession(ctx context.Context) error {
+	var vars []string
+	charset, collation := cn.cfg.Charset, cn.cfg.Collation
+	if id, ok := collationIds[collation]; ok && id <= 0xff && charset == "" {
+		collation = ""
+	}
+	switch {
+	case collation != "" && charset == "":
+		vars = append(vars, "NAMES "+collationCharset(collation)+" COLLATE "+collation)
+	case collation != "":
+		vars = append(vars, "NAMES "+charset+" COLLATE "+collation)
+	case charset != "":
+		vars = append(vars, "NAMES "+charset)
+	}
+	params := cn.cfg.Params
+	if _, ok := params["time_zone"]; cn.cfg.SyncTimeZone && !ok {
+		tz, err := timeZone(cn.cfg.location())
+		if err != nil {
+			return err
+		}
+		params = map[string]string{"time_zone": tz}
+		for name, v := range cn.cfg.Params {
+			params[name] = v
+		}
+	}
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		vars = append(vars, name+"="+quoteValue(params[name]))
+	}
+	if len(vars) > 0 {
+		q := "SET " + strings.Join(vars, ", ")
+		if cn.cfg.Debug {
+			log.Println("exec:", q)
+		}
+		if _, err := cn.exec(q); err != nil {
+			return err
+		}
+	}
+	if cn.cfg.OnConnect != nil {
+		return cn.cfg.OnConnect(ctx, cn)
+	}
+	return nil
+}
+
+// timeZone returns the MySQL time_zone value for loc: its name, or for UTC,
+// Local and unnamed zones, which MySQL does not know by name, its offset.
+// An offset is wrong on the other side of a daylight saving time change, so
+// it is an error if such a zone has one.
+func timeZone(loc *time.Location) (string, error) {
+	if name := loc.String(); loc != time.Local && name != "UTC" && name != "" {
+		return name, nil
+	}
+	year := time.Now().Year()
+	_, offset := time.Date(year, 1, 1, 0, 0, 0, 0, loc).Zone()
+	if _, summer := time.Date(year, 7, 1, 0, 0, 0, 0, loc).Zone(); summer != offset {
+		return "", fmt.Errorf("sync-time-zone: %s has daylight saving time, set loc to its name", loc)
+	}
+	sign := '+'
+	if offset < 0 {
+		sign, offset = '-', -offset
+	}
+	return fmt.Sprintf("%c%02d:%02d", sign, offset/3600, offset%3600/60), nil
+}
+
+// numericLiteral matches the SQL integer, decimal and float literals.
+var numericLiteral = regexp.MustCompile(`^[+-]?([0-9]+(\.[0-9]*)?|\.[0-9]+)([eE][+-]?[0-9]+)?$`)
+
+// quoteValue quotes a session variable value as a string literal, unless it
+// is a number or DEFAULT.
+func quoteValue(v string) string {
+	if numericLiteral.MatchString(v) || strings.EqualFold(v, "DEFAULT") {
+		return v
+	}
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `'`, `''`, -1)
+	return "'" + v + "'"
+}
+
+// checkPrimary returns an error if the server is read-only.
+func (cn *conn) checkPrimary() error {
+	r, err := cn.query("SELECT @@global.read_only, @@global.super_read_only")
+	if e, ok := err.(*Error); ok && e.Code == ER_UNKNOWN_SYSTEM_VARIABLE {
+		// super_read_only was added in 5.7.8, it implies read_only
+		r, err = cn.query("SELECT @@global.read_only")
+	}
+	if err != nil {
+		return err
+	}
+	v := make([]driver.Value, len(r.columns))
+	if err = r.Next(v); err != nil {
+		return err
+	}
+	if err = r.Close(); err != nil {
+		return err
+	}
+	for _, x := range v {
+		if x != nil && text(x) != "0" {
+			return fmt.Errorf("%s is read-only", cn.addr)
+		}
+	}
+	return nil
+}
+
 func (cn *conn) newComPacket(com byte) (p packet) {
 	cn.seq = 0
 	p = newPacket()
@@ -184,31 +347,137 @@ func (cn *conn) newComPacket(com byte) (p packet) {
 }
 
 func (cn *conn) recvPacket() (p packet, err error) {
-	cn.seq, err = p.recv(cn.bufrd, cn.seq)
-	return p, err
+	return cn.recvPacketBuf(nil)
+}
+
+// recvPacketBuf is like recvPacket but reads the payload into buf if it is
+// large enough.
+func (cn *conn) recvPacketBuf(buf []byte) (p packet, err error) {
+	if err = cn.flush(); err != nil {
+		return p, err
+	}
+	if cn.cfg.ReadTimeout > 0 {
+		cn.netconn.SetReadDeadline(cn.ioDeadline(cn.cfg.ReadTimeout))
+	}
+	if cn.seq, err = p.recvBuf(cn.bufrd, cn.seq, buf); err != nil {
+		return p, cn.netError("read", err)
+	}
+	return p, nil
 }
 
+// recvRow receives a row packet into the read buffer of cn, which is reused
+// by the next call. Values that point into the packet are therefore only
+// valid until then.
+func (cn *conn) recvRow() (p packet, err error) {
+	if p, err = cn.recvPacketBuf(cn.rbuf); err != nil {
+		return p, err
+	}
+	if cap(p.Bytes()) <= MAX_READ_BUFFER {
+		cn.rbuf = p.Bytes()
+	} else {
+		cn.rbuf = nil
+	}
+	return p, nil
+}
+
+// sendPacket writes p to the write buffer of cn, which is flushed before
+// the next packet is received, and releases p. Until the handshake is done
+// packets are written directly to the network connection.
 func (cn *conn) sendPacket(p packet) (err error) {
-	err = p.send(cn.netconn, cn.seq)
+	if cn.cfg.WriteTimeout > 0 {
+		cn.netconn.SetWriteDeadline(cn.ioDeadline(cn.cfg.WriteTimeout))
+	}
+	if cn.bufwr != nil {
+		err = p.send(cn.bufwr, cn.seq)
+	} else {
+		err = p.send(cn.netconn, cn.seq)
+	}
+	p.release()
 	cn.seq += 1
+	if err != nil {
+		return cn.netError("write", err)
+	}
+	return nil
+}
+
+// flush writes any buffered packets to the network connection.
+func (cn *conn) flush() error {
+	if cn.bufwr == nil || cn.bufwr.Buffered() == 0 {
+		return nil
+	}
+	if cn.cfg.WriteTimeout > 0 {
+		cn.netconn.SetWriteDeadline(cn.ioDeadline(cn.cfg.WriteTimeout))
+	}
+	if err := cn.bufwr.Flush(); err != nil {
+		return cn.netError("write", err)
+	}
+	return nil
+}
+
+// ioDeadline returns the deadline of a read or write with timeout d, which
+// does not extend past the deadline of the connection setup.
+func (cn *conn) ioDeadline(d time.Duration) time.Time {
+	t := time.Now().Add(d)
+	if !cn.deadline.IsZero() && cn.deadline.Before(t) {
+		return cn.deadline
+	}
+	return t
+}
+
+// netError marks the connection as broken after a timeout, since a partially
+// read or written packet leaves the protocol state undefined.
+func (cn *conn) netError(op string, err error) error {
+	err = timeoutError(op, err)
+	if _, ok := err.(*TimeoutError); ok {
+		cn.broken = true
+	}
+	return err
+}
+
+// serverError reads an ERR packet. If the server refuses a statement because
+// it has become read-only the connection is reported as bad, so that the
+// pool reconnects to the new primary.
+func (cn *conn) serverError(p *packet) error {
+	err := p.ReadErr()
+	if e := err.(*Error); cn.cfg.RequirePrimary && e.Code == ER_OPTION_PREVENTS_STATEMENT && strings.Contains(e.Message, "read-only") {
+		if cn.cfg.Debug {
+			log.Println(err)
+		}
+		cn.broken = true
+		return driver.ErrBadConn
+	}
 	return err
 }
 
+// IsValid implements driver.Validator so that connections broken by a
+// timeout are not returned to the pool.
+func (cn *conn) IsValid() bool {
+	return !cn.broken
+}
+
 func (cn *conn) hello() error {
 	challange, err := cn.readHello()
 	if err != nil {
 		return err
 	}
-	if cn.tls != nil {
-		if cn.serverCapabilities&CLIENT_SSL == 0 {
+	var flags uint32
+	if cfg := cn.cfg.TLS; cfg != nil && cn.cfg.SSLMode != SSLModeDisabled {
+		switch {
+		case cn.serverCapabilities&CLIENT_SSL != 0:
+			if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+				cfg = cfg.Clone()
+				cfg.ServerName = hostname(cn.addr)
+			}
+			flags |= CLIENT_SSL
+			if err = cn.writeHello(nil, flags); err != nil {
+				return err
+			}
+			cn.netconn = tls.Client(cn.netconn, cfg)
+		case cn.cfg.SSLMode != SSLModePreferred:
 			return fmt.Errorf("server does not support SSL")
 		}
-		if err = cn.writeHello(nil, CLIENT_SSL); err != nil {
-			return err
-		}
-		cn.netconn = tls.Client(cn.netconn, cn.tls)
 	}
-	if err := cn.writeHello(challange, 0); err != nil {
+	if err := cn.writeHello(challange, flags); err != nil {
 		return err
 	}
 
@@ -260,30 +529,32 @@ func (cn *conn) readHello() (challange []byte, err error) {
 func (cn *conn) writeHello(challange []byte, flags uint32) error {
 	p := newPacket()
 	flags |= CLIENT_PROTOCOL_41 | CLIENT_SECURE_CONNECTION | CLIENT_LOCAL_FILES
-	if len(cn.db) > 0 {
+	if len(cn.cfg.DB) > 0 {
 		flags |= CLIENT_CONNECT_WITH_DB
 	}
 	p.WriteUint32(flags)
 	p.WriteUint32(MAX_PACKET_SIZE)
-	if bytes.Compare(cn.version, []byte{5, 5, 3}) >= 0 {
+	if id, ok := collationIds[cn.cfg.Collation]; ok && id <= 0xff {
+		p.WriteByte(byte(id))
+	} else if bytes.Compare(cn.version, []byte{5, 5, 3}) >= 0 {
 		p.WriteByte(CHARSET_UTF8MB4)
 	} else {
 		p.WriteByte(CHARSET_UTF8)
 	}
 	p.Write(make([]byte, 23))
 
-	if flags&CLIENT_SSL == 0 {
-		p.WriteString(cn.user)
+	if challange != nil {
+		p.WriteString(cn.cfg.User)
 		p.WriteByte(0)
-		if cn.password != nil {
-			token := passwordToken(*cn.password, challange)
+		if cn.cfg.Password != nil {
+			token := passwordToken(*cn.cfg.Password, challange)
 			p.WriteByte(byte(len(token)))
 			p.Write(token)
 		} else {
 			p.WriteByte(0)
 		}
-		if len(cn.db) > 0 {
-			p.WriteString(cn.db)
+		if len(cn.cfg.DB) > 0 {
+			p.WriteString(cn.cfg.DB)
 			p.WriteByte(0)
 		}
 	}
@@ -342,15 +613,26 @@ func (cn *conn) Rollback() error {
 	return err
 }
 
+// Close sends COM_QUIT, unless the connection is broken, and closes the
+// network connection in any case.
 func (cn *conn) Close() (err error) {
+	defer func() {
+		if cerr := cn.netconn.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	if cn.broken {
+		return nil
+	}
 	p := cn.newComPacket(COM_QUIT)
-	if err := cn.sendPacket(p); err != nil {
+	if err = cn.sendPacket(p); err != nil {
 		return err
 	}
-	if _, err = cn.recvPacket(); err != nil {
-		return err
+	if _, err = cn.recvPacket(); err == io.EOF {
+		// the server may close the connection without a reply
+		return nil
 	}
-	return cn.netconn.Close()
+	return err
 }
 
 func (cn *conn) readColumns(n int) ([]column, error) {
@@ -365,13 +647,13 @@ func (cn *conn) readColumns(n int) ([]column, error) {
 			return nil, err
 		}
 		col := &cols[i]
-		p.SkipLCBytes()                // catalog
-		p.SkipLCBytes()                // schema
-		p.SkipLCBytes()                // table
-		p.SkipLCBytes()                // org_table
-		col.name, _ = p.ReadLCString() // name
-		p.SkipLCBytes()                // org_name
-		p.ReadLCUint64()               // 0x0c
+		p.SkipLCBytes() // catalog
+		col.schema, _ = p.ReadLCString()
+		col.table, _ = p.ReadLCString()
+		col.orgTable, _ = p.ReadLCString()
+		col.name, _ = p.ReadLCString()
+		col.orgName, _ = p.ReadLCString()
+		p.ReadLCUint64() // 0x0c
 		col.charset = p.ReadUint16()
 		col.length = p.ReadUint32()
 		col.coltype = p.ReadUint8()
@@ -392,7 +674,7 @@ func (cn *conn) Exec(query string, args []driver.Value) (driver.Result, error) {
 	if len(args) > 0 {
 		return nil, driver.ErrSkip // fall back to prepare/exec
 	}
-	if cn.debug {
+	if cn.cfg.Debug {
 		log.Println("exec:", query)
 	}
 	return cn.exec(query)
@@ -412,7 +694,7 @@ func (cn *conn) Query(query string, args []driver.Value) (driver.Rows, error) {
 	if len(args) > 0 {
 		return nil, driver.ErrSkip // fall back to prepare/exec
 	}
-	if cn.debug {
+	if cn.cfg.Debug {
 		log.Println("query:", query)
 	}
 	return cn.query(query)
@@ -422,6 +704,9 @@ func (cn *conn) query(query string) (r *result, err error) {
 	if len(query) > MAX_PACKET_SIZE {
 		return nil, fmt.Errorf("query exceeds %d bytes", MAX_PACKET_SIZE)
 	}
+	if query, err = cn.encodeString(query); err != nil {
+		return nil, err
+	}
 	p := cn.newComPacket(COM_QUERY)
 	p.WriteString(query)
 	if err = cn.sendPacket(p); err != nil {
@@ -439,7 +724,7 @@ func (cn *conn) query(query string) (r *result, err error) {
 			return nil, err
 		}
 	case ERR:
-		return nil, p.ReadErr()
+		return nil, cn.serverError(&p)
 	case LOCAL_INFILE:
 		p.ReadUint8()
 		fn := string(p.Bytes())
@@ -455,14 +740,109 @@ func (cn *conn) query(query string) (r *result, err error) {
 	return r, nil
 }
 
+var valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+
+// CheckNamedValue implements driver.NamedValueChecker. Arguments of the
+// types WriteArgs encodes natively are passed as is, rather than converted
+// by database/sql, which turns time.Duration into an integer and rejects
+// uint64 values above 1<<63. Other types are converted by their
+// driver.Valuer or, for types defined on a basic type, by their kind.
+func (cn *conn) CheckNamedValue(nv *driver.NamedValue) (err error) {
+	switch v := nv.Value.(type) {
+	case Vector:
+		if v == nil {
+			nv.Value = nil
+		}
+		return nil
+	case Decimal:
+		if v.Rat == nil {
+			nv.Value = nil
+		}
+		return nil
+	case *Decimal:
+		if v == nil || v.Rat == nil {
+			nv.Value = nil
+		} else {
+			nv.Value = *v
+		}
+		return nil
+	case *big.Rat:
+		if v == nil {
+			nv.Value = nil
+		}
+		return nil
+	case *big.Float:
+		if v == nil {
+			nv.Value = nil
+		}
+		return nil
+	case driver.Valuer:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Ptr && rv.IsNil() && rv.Type().Elem().Implements(valuerType) {
+			// like database/sql, a nil pointer to a type with a value
+			// receiver Value method is NULL
+			nv.Value = nil
+			return nil
+		}
+		if nv.Value, err = v.Value(); err != nil {
+			return err
+		}
+	}
+
+	switch nv.Value.(type) {
+	case nil, bool, string, []byte, float32, float64, time.Time, time.Duration,
+		int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		*big.Rat, *big.Float, Vector, Decimal:
+		return nil
+	case io.Reader:
+		// streamed as long data
+		if rv := reflect.ValueOf(nv.Value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+			nv.Value = nil
+		}
+		return nil
+	}
+	rv := reflect.ValueOf(nv.Value)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			nv.Value = nil
+			return nil
+		}
+		nv.Value = rv.Elem().Interface()
+		return cn.CheckNamedValue(nv)
+	case reflect.Bool:
+		nv.Value = rv.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		nv.Value = rv.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		nv.Value = rv.Uint()
+	case reflect.Float32, reflect.Float64:
+		nv.Value = rv.Float()
+	case reflect.String:
+		nv.Value = rv.String()
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("unsupported argument type %T", nv.Value)
+		}
+		nv.Value = rv.Bytes()
+	default:
+		return fmt.Errorf("unsupported argument type %T", nv.Value)
+	}
+	return nil
+}
+
 func (cn *conn) Prepare(query string) (driver.Stmt, error) {
-	if cn.debug {
+	if cn.cfg.Debug {
 		log.Printf("prepare: %s", query)
 	}
 	return cn.prepare(query)
 }
 
 func (cn *conn) prepare(query string) (st *stmt, err error) {
+	st = &stmt{cn: cn, qs: query}
+	if query, err = cn.encodeString(query); err != nil {
+		return nil, err
+	}
 	p := cn.newComPacket(COM_STMT_PREPARE)
 	p.WriteString(query)
 	if err := cn.sendPacket(p); err != nil {
@@ -472,7 +852,6 @@ func (cn *conn) prepare(query string) (st *stmt, err error) {
 		return nil, err
 	}
 
-	st = &stmt{cn: cn, qs: query}
 	switch p.FirstByte() {
 	case OK:
 		p.ReadUint8() // OK
@@ -492,7 +871,7 @@ func (cn *conn) prepare(query string) (st *stmt, err error) {
 			return nil, err
 		}
 	case ERR:
-		return nil, p.ReadErr()
+		return nil, cn.serverError(&p)
 	default:
 		return nil, fmt.Errorf("prepare: expected OK or ERR, got %v", p.FirstByte())
 	}
@@ -500,7 +879,7 @@ func (cn *conn) prepare(query string) (st *stmt, err error) {
 }
 
 func (cn *conn) sendLocalFile(r *result, fn string) error {
-	if !cn.allowLocalInfile {
+	if !cn.cfg.AllowLocalInfile {
 		return fmt.Errorf("client does not allow LOAD DATA LOCAL")
 	}
 	f, err := os.Open(fn)
@@ -509,22 +888,20 @@ func (cn *conn) sendLocalFile(r *result, fn string) error {
 	}
 	defer f.Close()
 
-	buf := make([]byte, MAX_DATA_CHUNK)
 	for {
-		n, err := f.Read(buf)
-		if err != nil && err != io.EOF {
+		p := newPacket()
+		n, err := p.ReadFrom(io.LimitReader(f, MAX_DATA_CHUNK))
+		if err != nil {
+			p.release()
 			return err
 		}
-		if n > 0 {
-			p := newPacket()
-			p.Write(buf[:n])
-			if err := cn.sendPacket(p); err != nil {
-				return err
-			}
-		}
-		if err == io.EOF {
+		if n == 0 {
+			p.release()
 			break
 		}
+		if err := cn.sendPacket(p); err != nil {
+			return err
+		}
 	}
 	p := newPacket()
 	if err = cn.sendPacket(p); err != nil {
@@ -540,7 +917,7 @@ func (cn *conn) sendLocalFile(r *result, fn string) error {
 			return err
 		}
 	case ERR:
-		return p.ReadErr()
+		return cn.serverError(&p)
 	default:
 		return fmt.Errorf("sendLocalFile: expected OK or ERR, got %v", p.FirstByte())
 	}
@@ -549,13 +926,13 @@ func (cn *conn) sendLocalFile(r *result, fn string) error {
 }
 
 func (cn *conn) logWarnings(warnings uint16) {
-	if cn.debug && warnings > 0 {
+	if cn.cfg.Debug && warnings > 0 {
 		log.Printf("warnings: %d\n", warnings)
 	}
 }
 
 func (st *stmt) Exec(args []driver.Value) (driver.Result, error) {
-	if st.cn.debug {
+	if st.cn.cfg.Debug {
 		log.Println("exec:", st.qs, args)
 	}
 	return st.exec(args)
@@ -573,42 +950,98 @@ func (st *stmt) exec(args []driver.Value) (r *result, err error) {
 }
 
 func (st *stmt) Query(args []driver.Value) (driver.Rows, error) {
-	if st.cn.debug {
+	if st.cn.cfg.Debug {
 		log.Println("query:", st.qs, args)
 	}
 	return st.query(args)
 }
 
-func (st *stmt) sendLongData(paramId int, b *bytes.Buffer) error {
-	for b.Len() > 0 {
+// sendLongData streams the value of parameter paramId from r in chunks of
+// MAX_DATA_CHUNK bytes. At least one chunk is sent, since the server only
+// omits the value from COM_STMT_EXECUTE if it received long data.
+func (st *stmt) sendLongData(paramId int, r io.Reader) error {
+	buf := make([]byte, MAX_DATA_CHUNK)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, buf)
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		if n == 0 && !first {
+			return nil
+		}
 		p := st.cn.newComPacket(COM_STMT_SEND_LONG_DATA)
 		p.WriteUint32(st.stmtId)
 		p.WriteUint16(uint16(paramId))
-		p.Write(b.Next(MAX_DATA_CHUNK))
+		p.Write(buf[:n])
 		if err := st.cn.sendPacket(p); err != nil {
 			return err
 		}
+		if last {
+			return nil
+		}
 	}
-	return nil
 }
 
-func (st *stmt) sendLongArgs(args []driver.Value) error {
+// sendLongArgs sends readers and strings or byte slices longer than
+// MAX_DATA_CHUNK as long data. WriteArgs leaves their values out.
+func (st *stmt) sendLongArgs(args []driver.Value) (err error) {
 	for i, a := range args {
 		switch t := a.(type) {
 		case []byte:
 			if len(t) > MAX_DATA_CHUNK {
-				return st.sendLongData(i, bytes.NewBuffer(t))
+				err = st.sendLongData(i, bytes.NewReader(t))
 			}
 		case string:
 			if len(t) > MAX_DATA_CHUNK {
-				return st.sendLongData(i, bytes.NewBufferString(t))
+				err = st.sendLongData(i, strings.NewReader(t))
 			}
+		case io.Reader:
+			err = st.sendLongData(i, t)
+		}
+		if err != nil {
+			// discard the long data the server has received so far
+			st.reset()
+			return err
 		}
 	}
 	return nil
 }
 
+// reset resets the long data of st.
+func (st *stmt) reset() error {
+	p := st.cn.newComPacket(COM_STMT_RESET)
+	p.WriteUint32(st.stmtId)
+	if err := st.cn.sendPacket(p); err != nil {
+		return err
+	}
+	p, err := st.cn.recvPacket()
+	if err != nil {
+		return err
+	}
+	switch p.FirstByte() {
+	case OK:
+		return nil
+	case ERR:
+		return st.cn.serverError(&p)
+	default:
+		return fmt.Errorf("reset: expected OK or ERR, got %v", p.FirstByte())
+	}
+}
+
 func (st *stmt) query(args []driver.Value) (r *result, err error) {
+	if st.cn.enc != nil {
+		encoded := make([]driver.Value, len(args))
+		for i, a := range args {
+			if s, ok := a.(string); ok {
+				if a, err = st.cn.encodeString(s); err != nil {
+					return nil, err
+				}
+			}
+			encoded[i] = a
+		}
+		args = encoded
+	}
 	if err = st.sendLongArgs(args); err != nil {
 		return nil, err
 	}
@@ -624,7 +1057,7 @@ func (st *stmt) query(args []driver.Value) (r *result, err error) {
 		}
 		p.WriteMask(nullMask)
 		p.WriteByte(1)
-		if err := p.WriteArgs(args); err != nil {
+		if err := p.WriteArgs(args, st.cn.cfg); err != nil {
 			return nil, err
 		}
 	}
@@ -643,7 +1076,7 @@ func (st *stmt) query(args []driver.Value) (r *result, err error) {
 			return nil, err
 		}
 	case ERR:
-		return nil, p.ReadErr()
+		return nil, st.cn.serverError(&p)
 	default:
 		n, _ := p.ReadLCUint64()
 		if r.columns, err = st.cn.readColumns(int(n)); err != nil {
@@ -658,9 +1091,11 @@ func (st *stmt) NumInput() int {
 }
 
 func (st *stmt) Close() error {
-	if st.cn.debug {
+	if st.cn.cfg.Debug {
 		log.Println("close")
 	}
+	// COM_STMT_CLOSE has no response, so it is sent along with the next
+	// command instead of in a write of its own.
 	p := st.cn.newComPacket(COM_STMT_CLOSE)
 	p.WriteUint32(st.stmtId)
 	if err := st.cn.sendPacket(p); err != nil {
@@ -676,7 +1111,7 @@ func (r *result) ReadOK(p *packet) error {
 }
 
 func (r *result) ReadWarnings() error {
-	if r.warnings > 0 && (r.cn.strict || r.cn.debug) {
+	if r.warnings > 0 && (r.cn.cfg.Strict || r.cn.cfg.Debug) {
 		w, err := r.cn.query("show warnings")
 		if err != nil {
 			return err
@@ -685,13 +1120,13 @@ func (r *result) ReadWarnings() error {
 		for {
 			switch err := w.Next(v); err {
 			case nil:
-				if r.cn.debug {
-					log.Printf("%s %s %s", v[0], v[1], v[2])
+				if r.cn.cfg.Debug {
+					log.Printf("%s %s %s", text(v[0]), text(v[1]), text(v[2]))
 				}
-				if r.cn.strict {
-					if string(v[0].([]byte)) != "Note" {
+				if r.cn.cfg.Strict {
+					if text(v[0]) != "Note" {
 						w.Close()
-						return fmt.Errorf("%s %s %s", v[0], v[1], v[2])
+						return fmt.Errorf("%s %s %s", text(v[0]), text(v[1]), text(v[2]))
 					}
 				}
 			case io.EOF:
@@ -704,6 +1139,15 @@ func (r *result) ReadWarnings() error {
 	return nil
 }
 
+// text formats a value of an internal query, which depending on the DSN
+// may be returned as []byte, string or a number.
+func text(v driver.Value) string {
+	if b, ok := v.([]byte); ok {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
 func (r *result) RowsAffected() (int64, error) {
 	return r.rowsAffected, nil
 }
@@ -721,6 +1165,9 @@ func (r *result) Columns() []string {
 }
 
 func (r *result) Close() error {
+	// Values returned by the last call to Next may still be in use, so
+	// leave them alone and drain the rest into a fresh buffer.
+	r.cn.rbuf = nil
 	for {
 		err := r.Next(nil)
 		switch err {
@@ -738,14 +1185,14 @@ func (r *result) Next(dest []driver.Value) (err error) {
 	if r.closed {
 		return io.EOF
 	}
-	p, err := r.cn.recvPacket()
+	p, err := r.cn.recvRow()
 	if err != nil {
 		return err
 	}
 
 	switch {
 	case p.FirstByte() == ERR:
-		return p.ReadErr()
+		return r.cn.serverError(&p)
 	case p.FirstByte() == EOF && p.Len() <= 8: // can be LC integer
 		r.warnings, r.status = p.ReadEOF()
 		r.closed = true
@@ -760,20 +1207,30 @@ func (r *result) Next(dest []driver.Value) (err error) {
 			if h := p.ReadUint8(); h != 0 {
 				return fmt.Errorf("next: expected 0, got %v", h)
 			}
-			nullMask := p.ReadMask(len(r.columns) + 2)
-			nullMask = nullMask[2:]
+			nullMask := p.Next((len(r.columns) + 2 + 7) / 8)
 			for i := range dest {
-				dest[i], err = p.ReadValue(r.columns[i].coltype, r.columns[i].flags, nullMask[i])
+				isnull := nullMask[(i+2)/8]>>byte((i+2)%8)&1 > 0
+				dest[i], err = p.ReadValue(r.columns[i].coltype, r.columns[i].flags, isnull, r.cn.cfg)
 				if err != nil {
 					return err
 				}
+				if r.cn.cfg.DecodeCharset {
+					if dest[i], err = r.cn.decodeValue(&r.columns[i], dest[i]); err != nil {
+						return err
+					}
+				}
 			}
 		} else {
 			for i := range dest {
-				dest[i], err = p.ReadTextValue(r.columns[i].coltype, r.columns[i].flags)
+				dest[i], err = p.ReadTextValue(r.columns[i].coltype, r.columns[i].flags, r.cn.cfg)
 				if err != nil {
 					return err
 				}
+				if r.cn.cfg.DecodeCharset {
+					if dest[i], err = r.cn.decodeValue(&r.columns[i], dest[i]); err != nil {
+						return err
+					}
+				}
 			}
 		}
 	}
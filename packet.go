@@ -6,22 +6,47 @@ import (
 	"fmt"
 	"io"
 	"math"
+	"math/big"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type packet struct {
 	bytes.Buffer
+	pooled *[]byte // returned to packetPool by release
 }
 
+// packetPool holds the buffers of sent packets for reuse by newPacket.
+var packetPool = sync.Pool{New: func() interface{} { return new([]byte) }}
+
 func newPacket() (p packet) {
+	p.pooled = packetPool.Get().(*[]byte)
+	p.Buffer = *bytes.NewBuffer((*p.pooled)[:0])
 	p.Write([]byte{0, 0, 0, 0})
 	return p
 }
 
-func readHeader(r io.Reader, seq byte) (int, error) {
-	var h [4]byte
-	_, err := io.ReadFull(r, h[:])
+// release returns the buffer of p to the pool if it came from there. p must
+// not be used afterwards.
+func (p *packet) release() {
+	if p.pooled == nil {
+		return
+	}
+	if b := p.Bytes(); cap(b) <= MAX_POOL_BUFFER {
+		*p.pooled = b[:0]
+		packetPool.Put(p.pooled)
+	}
+	p.pooled = nil
+	p.Reset()
+}
+
+// readHeader reads a packet header into h, which must have room for 4 bytes,
+// and returns the payload size.
+func readHeader(r io.Reader, seq byte, h []byte) (int, error) {
+	h = h[:4]
+	_, err := io.ReadFull(r, h)
 	if err != nil {
 		return 0, err
 	}
@@ -36,17 +61,30 @@ func readHeader(r io.Reader, seq byte) (int, error) {
 }
 
 func (p *packet) recv(r io.Reader, seq byte) (byte, error) {
-	size, err := readHeader(r, seq)
+	return p.recvBuf(r, seq, nil)
+}
+
+// recvBuf is like recv but reads the payload into buf if it is large
+// enough. The previous contents of buf are overwritten.
+func (p *packet) recvBuf(r io.Reader, seq byte, buf []byte) (byte, error) {
+	if cap(buf) < 4 {
+		buf = make([]byte, 4)
+	}
+	size, err := readHeader(r, seq, buf)
 	if err != nil {
 		return 0, err
 	}
-	buf := make([]byte, size)
+	if size > cap(buf) {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
 	if _, err = io.ReadFull(r, buf); err != nil {
 		return 0, err
 	}
 	for size == MAX_PACKET_SIZE {
 		seq += 1
-		if size, err = readHeader(r, seq); err != nil {
+		var h [4]byte
+		if size, err = readHeader(r, seq, h[:]); err != nil {
 			return 0, err
 		}
 		m := len(buf)
@@ -126,11 +164,11 @@ func (p *packet) ReadLCString() (v string, isnull bool) {
 
 func (p *packet) ReadErr() error {
 	p.ReadByte()
-	errorCode := p.ReadUint16()
+	e := &Error{Code: p.ReadUint16()}
 	p.ReadByte()
-	state := string(p.Next(5))
-	info := string(p.Bytes())
-	return fmt.Errorf("ERROR %d (%s): %s", errorCode, state, info)
+	e.State = string(p.Next(5))
+	e.Message = string(p.Bytes())
+	return e
 }
 
 func (p *packet) ReadEOF() (warnings, status uint16) {
@@ -216,21 +254,45 @@ func (p *packet) WriteMask(mask []bool) {
 	p.Write(buf)
 }
 
-func (p *packet) WriteArgs(args []driver.Value) error {
+// WriteArgs writes the types and values of args. Times are written in the
+// location of cfg. The values of long arguments are left out, since they
+// are sent by sendLongArgs.
+func (p *packet) WriteArgs(args []driver.Value, cfg *Config) error {
 	v := packet{}
 	for i := range args {
 		switch t := args[i].(type) {
 		case nil:
 			p.WriteUint16(MYSQL_TYPE_NULL)
-		case int:
-			p.WriteUint16(MYSQL_TYPE_LONG)
-			v.WriteUint32(uint32(t))
+		case int8:
+			p.WriteUint16(MYSQL_TYPE_TINY)
+			v.WriteByte(byte(t))
+		case uint8:
+			p.WriteUint16(MYSQL_TYPE_TINY | UNSIGNED_PARAM)
+			v.WriteByte(t)
+		case int16:
+			p.WriteUint16(MYSQL_TYPE_SHORT)
+			v.WriteUint16(uint16(t))
+		case uint16:
+			p.WriteUint16(MYSQL_TYPE_SHORT | UNSIGNED_PARAM)
+			v.WriteUint16(t)
 		case int32:
 			p.WriteUint16(MYSQL_TYPE_LONG)
 			v.WriteUint32(uint32(t))
+		case uint32:
+			p.WriteUint16(MYSQL_TYPE_LONG | UNSIGNED_PARAM)
+			v.WriteUint32(t)
+		case int:
+			p.WriteUint16(MYSQL_TYPE_LONGLONG)
+			v.WriteUint64(uint64(t))
 		case int64:
 			p.WriteUint16(MYSQL_TYPE_LONGLONG)
 			v.WriteUint64(uint64(t))
+		case uint:
+			p.WriteUint16(MYSQL_TYPE_LONGLONG | UNSIGNED_PARAM)
+			v.WriteUint64(uint64(t))
+		case uint64:
+			p.WriteUint16(MYSQL_TYPE_LONGLONG | UNSIGNED_PARAM)
+			v.WriteUint64(t)
 		case float32:
 			p.WriteUint16(MYSQL_TYPE_FLOAT)
 			v.WriteUint32(math.Float32bits(t))
@@ -256,28 +318,57 @@ func (p *packet) WriteArgs(args []driver.Value) error {
 				v.WriteLCUint64(uint64(len(t)))
 				v.Write(t)
 			}
+		case io.Reader:
+			p.WriteUint16(MYSQL_TYPE_BLOB) // sent as long data
+		case Vector:
+			p.WriteUint16(MYSQL_TYPE_BLOB)
+			b := t.bytes()
+			v.WriteLCUint64(uint64(len(b)))
+			v.Write(b)
+		case *big.Rat, *big.Float, Decimal:
+			s, err := decimalString(t)
+			if err != nil {
+				return err
+			}
+			p.WriteUint16(MYSQL_TYPE_NEWDECIMAL)
+			v.WriteLCUint64(uint64(len(s)))
+			v.WriteString(s)
 		case time.Time:
-			t = t.UTC()
+			t = t.In(cfg.location())
 			if t.IsZero() {
 				p.WriteUint16(MYSQL_TYPE_DATETIME)
 				v.WriteByte(0)
 			} else {
 				p.WriteUint16(MYSQL_TYPE_DATETIME)
-				v.WriteByte(7)
+				us := t.Nanosecond() / 1000
+				if us > 0 {
+					v.WriteByte(11)
+				} else {
+					v.WriteByte(7)
+				}
 				v.WriteUint16(uint16(t.Year()))
 				v.WriteByte(byte(t.Month()))
 				v.WriteByte(byte(t.Day()))
 				v.WriteByte(byte(t.Hour()))
 				v.WriteByte(byte(t.Minute()))
 				v.WriteByte(byte(t.Second()))
+				if us > 0 {
+					v.WriteUint32(uint32(us))
+				}
 			}
 		case time.Duration:
 			p.WriteUint16(MYSQL_TYPE_TIME)
-			v.WriteByte(8)
-			s, neg := t/1000000000, 0
-			if s < 0 {
-				s, neg = -s, 1
+			neg := 0
+			if t < 0 {
+				t, neg = -t, 1
+			}
+			us := t % time.Second / time.Microsecond
+			if us > 0 {
+				v.WriteByte(12)
+			} else {
+				v.WriteByte(8)
 			}
+			s := t / time.Second
 			ss, s := s%60, s/60
 			mm, s := s%60, s/60
 			hh, s := s%24, s/24
@@ -287,6 +378,9 @@ func (p *packet) WriteArgs(args []driver.Value) error {
 			v.WriteByte(byte(hh))
 			v.WriteByte(byte(mm))
 			v.WriteByte(byte(ss))
+			if us > 0 {
+				v.WriteUint32(uint32(us))
+			}
 		default:
 			return fmt.Errorf("invalid parameter: %v", args[i])
 		}
@@ -295,8 +389,13 @@ func (p *packet) WriteArgs(args []driver.Value) error {
 	return err
 }
 
-func (p *packet) ReadValue(coltype byte, flags uint16, isnull bool) (v interface{}, err error) {
+// ReadValue reads a value of the binary protocol, as configured by cfg.
+func (p *packet) ReadValue(coltype byte, flags uint16, isnull bool, cfg *Config) (v interface{}, err error) {
+	loc := cfg.location()
 	if isnull {
+		if cfg.NullTime {
+			return nil, nil
+		}
 		switch coltype {
 		case MYSQL_TYPE_TIMESTAMP, MYSQL_TYPE_DATETIME, MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE:
 			return time.Time{}, nil
@@ -364,7 +463,7 @@ func (p *packet) ReadValue(coltype byte, flags uint16, isnull bool) (v interface
 					ns = int(p.ReadUint32()) * 1000
 				}
 			}
-			v = time.Date(y, m, d, hh, mm, ss, ns, time.UTC)
+			v = time.Date(y, m, d, hh, mm, ss, ns, loc)
 		} else {
 			v = time.Time{}
 		}
@@ -392,11 +491,22 @@ func (p *packet) ReadValue(coltype byte, flags uint16, isnull bool) (v interface
 
 	case MYSQL_TYPE_STRING, MYSQL_TYPE_VARCHAR, MYSQL_TYPE_VAR_STRING,
 		MYSQL_TYPE_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_MEDIUM_BLOB,
-		MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_BIT:
+		MYSQL_TYPE_DECIMAL, MYSQL_TYPE_NEWDECIMAL, MYSQL_TYPE_JSON,
+		MYSQL_TYPE_ENUM, MYSQL_TYPE_SET, MYSQL_TYPE_TINY_BLOB, MYSQL_TYPE_GEOMETRY:
 		if s, isnull := p.ReadLCBytes(); !isnull {
 			v = s
 		}
 
+	case MYSQL_TYPE_BIT:
+		if s, isnull := p.ReadLCBytes(); !isnull {
+			v = bitValue(s)
+		}
+
+	case MYSQL_TYPE_VECTOR:
+		if s, isnull := p.ReadLCBytes(); !isnull {
+			v, err = vectorValue(s)
+		}
+
 	default:
 		return nil, fmt.Errorf("unkown colymn type: %d", coltype)
 	}
@@ -404,22 +514,50 @@ func (p *packet) ReadValue(coltype byte, flags uint16, isnull bool) (v interface
 	return v, err
 }
 
-func (p *packet) ReadTextValue(coltype byte, flags uint16) (v interface{}, err error) {
+// ReadTextValue reads a value of the text protocol, as configured by cfg.
+func (p *packet) ReadTextValue(coltype byte, flags uint16, cfg *Config) (v interface{}, err error) {
 	b, isnull := p.ReadLCBytes()
+	if isnull && cfg.NullTime {
+		return nil, nil
+	}
+	loc := cfg.location()
+
+	if cfg.TypedText && !isnull {
+		if bits, ok := intBits[coltype]; ok {
+			return parseInt(b, bits, flags&UNSIGNED_FLAG != 0)
+		}
+		switch coltype {
+		case MYSQL_TYPE_FLOAT:
+			f, err := strconv.ParseFloat(string(b), 32)
+			return float32(f), err
+		case MYSQL_TYPE_DOUBLE:
+			return strconv.ParseFloat(string(b), 64)
+		}
+	}
 
 	switch coltype {
 	case MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
-		if isnull || bytes.Equal(b, []byte("0000-00-00 00:00:00")) {
+		if isnull || bytes.HasPrefix(b, []byte("0000-00-00 00:00:00")) {
 			return time.Time{}, nil
 		} else {
-			return time.Parse("2006-01-02 15:04:05", string(b))
+			return time.ParseInLocation("2006-01-02 15:04:05", string(b), loc)
 		}
 	case MYSQL_TYPE_DATE:
 		if isnull || bytes.Equal(b, []byte("0000-00-00")) {
 			return time.Time{}, nil
 		} else {
-			return time.Parse("2006-01-02", string(b))
+			return time.ParseInLocation("2006-01-02", string(b), loc)
+		}
+	case MYSQL_TYPE_BIT:
+		if isnull {
+			return nil, nil
+		}
+		return bitValue(b), nil
+	case MYSQL_TYPE_VECTOR:
+		if isnull {
+			return nil, nil
 		}
+		return vectorValue(b)
 	case MYSQL_TYPE_TIME:
 		if isnull {
 			return time.Duration(0), nil
@@ -439,3 +577,54 @@ func (p *packet) ReadTextValue(coltype byte, flags uint16) (v interface{}, err e
 	}
 	panic("unreachable")
 }
+
+// bitValue returns the value of a BIT column, which is sent big-endian.
+func bitValue(b []byte) (v uint64) {
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// intBits are the sizes of the integer types.
+var intBits = map[byte]int{
+	MYSQL_TYPE_TINY:     8,
+	MYSQL_TYPE_SHORT:    16,
+	MYSQL_TYPE_YEAR:     16,
+	MYSQL_TYPE_INT24:    32,
+	MYSQL_TYPE_LONG:     32,
+	MYSQL_TYPE_LONGLONG: 64,
+}
+
+// parseInt parses a text protocol integer into the type ReadValue returns
+// for it.
+func parseInt(b []byte, bits int, unsigned bool) (interface{}, error) {
+	if unsigned {
+		u, err := strconv.ParseUint(string(b), 10, bits)
+		if err != nil {
+			return nil, err
+		}
+		switch bits {
+		case 8:
+			return uint8(u), nil
+		case 16:
+			return uint16(u), nil
+		case 32:
+			return uint32(u), nil
+		}
+		return u, nil
+	}
+	i, err := strconv.ParseInt(string(b), 10, bits)
+	if err != nil {
+		return nil, err
+	}
+	switch bits {
+	case 8:
+		return int8(i), nil
+	case 16:
+		return int16(i), nil
+	case 32:
+		return int32(i), nil
+	}
+	return i, nil
+}
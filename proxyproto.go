@@ -0,0 +1,127 @@
+package mysql
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// PROXY protocol v2 TLV types, see
+// https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+const (
+	PP2_TYPE_ALPN      = 0x01
+	PP2_TYPE_AUTHORITY = 0x02
+	PP2_TYPE_CRC32C    = 0x03
+	PP2_TYPE_NOOP      = 0x04
+	PP2_TYPE_UNIQUE_ID = 0x05
+	PP2_TYPE_SSL       = 0x20
+	PP2_TYPE_NETNS     = 0x30
+)
+
+// ProxyTLV is a type-length-value field sent in a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// writeProxyHeader announces the client address of the connection to a
+// proxy or server that expects the PROXY protocol.
+func (cn *conn) writeProxyHeader() error {
+	h, err := proxyHeader(cn.cfg.ProxyProtocol, cn.netconn.LocalAddr(), cn.netconn.RemoteAddr(), cn.cfg.ProxyTLVs)
+	if err != nil {
+		return err
+	}
+	_, err = cn.netconn.Write(h)
+	return err
+}
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyHeader returns the PROXY protocol header announcing a connection
+// from src to dst.
+func proxyHeader(version int, src, dst net.Addr, tlvs []ProxyTLV) ([]byte, error) {
+	srcIP, srcPort, dstIP, dstPort, ok := proxyAddrs(src, dst)
+
+	switch version {
+	case 1:
+		if !ok {
+			return []byte("PROXY UNKNOWN\r\n"), nil
+		}
+		proto := "TCP6"
+		if srcIP.To4() != nil {
+			proto = "TCP4"
+		}
+		return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, srcPort, dstPort)), nil
+
+	case 2:
+		var fam byte
+		var addrs []byte
+		if ok {
+			if ip4 := srcIP.To4(); ip4 != nil {
+				fam = 0x11 // TCP over IPv4
+				addrs = append(addrs, ip4...)
+				addrs = append(addrs, dstIP.To4()...)
+			} else {
+				fam = 0x21 // TCP over IPv6
+				addrs = append(addrs, srcIP.To16()...)
+				addrs = append(addrs, dstIP.To16()...)
+			}
+			addrs = appendUint16(addrs, uint16(srcPort))
+			addrs = appendUint16(addrs, uint16(dstPort))
+		}
+		for _, tlv := range tlvs {
+			if len(tlv.Value) > 0xffff {
+				return nil, fmt.Errorf("proxy protocol TLV %#x exceeds 65535 bytes", tlv.Type)
+			}
+			addrs = append(addrs, tlv.Type)
+			addrs = appendUint16(addrs, uint16(len(tlv.Value)))
+			addrs = append(addrs, tlv.Value...)
+		}
+		if len(addrs) > 0xffff {
+			return nil, fmt.Errorf("proxy protocol header exceeds 65535 bytes")
+		}
+		h := append([]byte(nil), proxyV2Signature...)
+		h = append(h, 0x21, fam) // version 2, PROXY command
+		h = appendUint16(h, uint16(len(addrs)))
+		return append(h, addrs...), nil
+	}
+	return nil, fmt.Errorf("invalid proxy protocol version: %d", version)
+}
+
+// proxyAddrs returns the IP addresses and ports of src and dst if both are
+// TCP addresses of the same family.
+func proxyAddrs(src, dst net.Addr) (srcIP net.IP, srcPort int, dstIP net.IP, dstPort int, ok bool) {
+	var err error
+	if srcIP, srcPort, err = splitIPPort(src); err != nil {
+		return
+	}
+	if dstIP, dstPort, err = splitIPPort(dst); err != nil {
+		return
+	}
+	ok = (srcIP.To4() == nil) == (dstIP.To4() == nil)
+	return
+}
+
+func splitIPPort(addr net.Addr) (net.IP, int, error) {
+	if a, ok := addr.(*net.TCPAddr); ok {
+		return a.IP, a.Port, nil
+	}
+	if addr == nil || addr.Network() != "tcp" {
+		return nil, 0, fmt.Errorf("not a TCP address: %v", addr)
+	}
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil, 0, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("not an IP address: %s", host)
+	}
+	p, err := strconv.Atoi(port)
+	return ip, p, err
+}
+
+// appendUint16 appends v in network byte order.
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
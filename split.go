@@ -0,0 +1,426 @@
+package mysql
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SplitConnector is a driver.Connector for a primary server with a set of
+// replicas. Read-only transactions (sql.TxOptions.ReadOnly) and SELECT
+// statements outside of transactions are sent to a replica, everything else
+// to the primary.
+//
+// Replicas are checked in the background every CheckInterval. A replica is
+// excluded while it cannot be reached, while replication is stopped or while
+// it lags more than MaxLag behind the primary. Reads are balanced round-robin
+// over the remaining replicas and go to the primary if there are none.
+//
+// Note that reads from a replica may not see writes just made outside of a
+// transaction on the primary.
+type SplitConnector struct {
+	Primary       *Config
+	Replicas      []*Config
+	MaxLag        time.Duration // 0 disables the lag check
+	CheckInterval time.Duration // defaults to 5s
+
+	start  sync.Once
+	close  sync.Once
+	stop   chan struct{}
+	next   uint32
+	mu     sync.Mutex
+	health []bool
+	checks []*conn
+}
+
+// NewSplitConnector returns a SplitConnector for use with sql.OpenDB.
+func NewSplitConnector(primary *Config, replicas ...*Config) *SplitConnector {
+	return &SplitConnector{Primary: primary, Replicas: replicas}
+}
+
+func (c *SplitConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.start.Do(c.startChecks)
+	primary, err := connect(ctx, c.Primary)
+	if err != nil {
+		return nil, err
+	}
+	return &splitConn{c: c, primary: primary, replicaId: -1}, nil
+}
+
+func (c *SplitConnector) Driver() driver.Driver {
+	return &mysql{}
+}
+
+// Close stops the health checks. It is called by sql.DB.Close.
+func (c *SplitConnector) Close() error {
+	c.start.Do(func() {})
+	c.close.Do(func() {
+		if c.stop != nil {
+			close(c.stop)
+		}
+	})
+	return nil
+}
+
+func (c *SplitConnector) startChecks() {
+	c.health = make([]bool, len(c.Replicas))
+	for i := range c.health {
+		c.health[i] = true
+	}
+	c.checks = make([]*conn, len(c.Replicas))
+	c.stop = make(chan struct{})
+
+	interval := c.CheckInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			c.check()
+			select {
+			case <-t.C:
+			case <-c.stop:
+				for _, cn := range c.checks {
+					if cn != nil {
+						cn.Close()
+					}
+				}
+				return
+			}
+		}
+	}()
+}
+
+// check updates the health of all replicas.
+func (c *SplitConnector) check() {
+	for i, cfg := range c.Replicas {
+		ok, err := c.checkReplica(i)
+		if err != nil && cfg.Debug {
+			log.Printf("replica %s: %v\n", cfg.Addr, err)
+		}
+		c.mu.Lock()
+		c.health[i] = ok
+		c.mu.Unlock()
+	}
+}
+
+func (c *SplitConnector) checkReplica(i int) (bool, error) {
+	cn := c.checks[i]
+	if cn == nil || cn.broken {
+		var err error
+		if cn, err = connect(context.Background(), c.Replicas[i]); err != nil {
+			return false, err
+		}
+		c.checks[i] = cn
+	}
+	lag, err := cn.replicationLag()
+	if err != nil {
+		cn.broken = true
+		cn.netconn.Close()
+		return false, err
+	}
+	if lag < 0 {
+		return false, fmt.Errorf("replication is not running")
+	}
+	if c.MaxLag > 0 && lag > c.MaxLag {
+		return false, fmt.Errorf("replication lag %v exceeds %v", lag, c.MaxLag)
+	}
+	return true, nil
+}
+
+// replica returns the index of the next healthy replica, or -1 if there
+// is none.
+func (c *SplitConnector) replica() int {
+	n := int(atomic.AddUint32(&c.next, 1) - 1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i := range c.health {
+		if j := (n + i) % len(c.health); c.health[j] {
+			return j
+		}
+	}
+	return -1
+}
+
+func (c *SplitConnector) healthy(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.health[i]
+}
+
+// replicationLag returns how far the server lags behind its source, or a
+// negative duration if replication is not running. A server that is not a
+// replica has no lag.
+func (cn *conn) replicationLag() (time.Duration, error) {
+	r, err := cn.query("SHOW REPLICA STATUS")
+	if e, ok := err.(*Error); ok && e.Code == ER_PARSE_ERROR {
+		// before 8.0.22
+		r, err = cn.query("SHOW SLAVE STATUS")
+	}
+	if err != nil {
+		return 0, err
+	}
+	v := make([]driver.Value, len(r.columns))
+	switch err = r.Next(v); err {
+	case nil:
+	case io.EOF:
+		return 0, nil
+	default:
+		return 0, err
+	}
+	if err = r.Close(); err != nil {
+		return 0, err
+	}
+	for i, col := range r.columns {
+		if col.name == "Seconds_Behind_Source" || col.name == "Seconds_Behind_Master" {
+			if v[i] == nil {
+				return -1, nil
+			}
+			s, err := strconv.Atoi(text(v[i]))
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s: %s", col.name, text(v[i]))
+			}
+			return time.Duration(s) * time.Second, nil
+		}
+	}
+	return 0, fmt.Errorf("replica status has no Seconds_Behind_Source")
+}
+
+// primaryOnly are the parts of a SELECT that lock, write or read the state
+// of the session, which only the primary connection has.
+var primaryOnly = []string{
+	"FOR UPDATE", "FOR SHARE", "LOCK IN SHARE MODE", " INTO ",
+	"GET_LOCK", "RELEASE_LOCK", "RELEASE_ALL_LOCKS", "IS_USED_LOCK", "IS_FREE_LOCK",
+	"LAST_INSERT_ID", "FOUND_ROWS", "ROW_COUNT", "CONNECTION_ID",
+	"@", // user and session variables
+}
+
+// isReadQuery reports whether query is a SELECT that can run on a replica.
+func isReadQuery(query string) bool {
+	q := strings.ToUpper(strings.TrimSpace(query))
+	if !strings.HasPrefix(q, "SELECT") {
+		return false
+	}
+	for _, s := range primaryOnly {
+		if strings.Contains(q, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitConn routes statements to a connection to the primary or to a
+// replica, which is connected on first use.
+type splitConn struct {
+	c         *SplitConnector
+	primary   *conn
+	replica   *conn
+	replicaId int
+	tx        *conn          // connection of the current transaction
+	stmts     map[*conn]int  // number of open statements on replicas
+	retired   map[*conn]bool // replaced replicas with open statements
+}
+
+// route returns the connection to use for query.
+func (sc *splitConn) route(query string) *conn {
+	if sc.tx != nil {
+		return sc.tx
+	}
+	if isReadQuery(query) {
+		return sc.reader()
+	}
+	return sc.primary
+}
+
+// reader returns the connection to a healthy replica, or the primary if
+// there is none.
+func (sc *splitConn) reader() *conn {
+	if sc.replica != nil && (sc.replica.broken || !sc.c.healthy(sc.replicaId)) {
+		// statements prepared on the replica are still usable, so it
+		// is only closed with the last of them
+		if sc.stmts[sc.replica] > 0 {
+			if sc.retired == nil {
+				sc.retired = make(map[*conn]bool)
+			}
+			sc.retired[sc.replica] = true
+		} else {
+			sc.replica.Close()
+		}
+		sc.replica, sc.replicaId = nil, -1
+	}
+	for tries := len(sc.c.Replicas); sc.replica == nil && tries > 0; tries-- {
+		i := sc.c.replica()
+		if i < 0 {
+			break
+		}
+		cn, err := connect(context.Background(), sc.c.Replicas[i])
+		if err != nil {
+			if sc.c.Replicas[i].Debug {
+				log.Printf("replica %s: %v\n", sc.c.Replicas[i].Addr, err)
+			}
+			sc.c.mu.Lock()
+			sc.c.health[i] = false
+			sc.c.mu.Unlock()
+			continue
+		}
+		sc.replica, sc.replicaId = cn, i
+	}
+	if sc.replica == nil {
+		return sc.primary
+	}
+	return sc.replica
+}
+
+func (sc *splitConn) Prepare(query string) (driver.Stmt, error) {
+	cn := sc.route(query)
+	st, err := cn.Prepare(query)
+	if err != nil || cn == sc.primary {
+		return st, err
+	}
+	if sc.stmts == nil {
+		sc.stmts = make(map[*conn]int)
+	}
+	sc.stmts[cn]++
+	return &splitStmt{Stmt: st, sc: sc, cn: cn, query: query}, nil
+}
+
+func (sc *splitConn) Exec(query string, args []driver.Value) (driver.Result, error) {
+	if sc.tx != nil {
+		return sc.tx.Exec(query, args)
+	}
+	return sc.primary.Exec(query, args)
+}
+
+func (sc *splitConn) Query(query string, args []driver.Value) (driver.Rows, error) {
+	return sc.route(query).Query(query, args)
+}
+
+func (sc *splitConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return sc.primary.CheckNamedValue(nv)
+}
+
+func (sc *splitConn) Begin() (driver.Tx, error) {
+	return sc.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (sc *splitConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, fmt.Errorf("isolation level not supported")
+	}
+	if !opts.ReadOnly {
+		tx, err := sc.primary.Begin()
+		if err != nil {
+			return nil, err
+		}
+		sc.tx = sc.primary
+		return &splitTx{sc, tx}, nil
+	}
+	cn := sc.reader()
+	if _, err := cn.Exec("START TRANSACTION READ ONLY", nil); err != nil {
+		return nil, err
+	}
+	sc.tx = cn
+	return &splitTx{sc, cn}, nil
+}
+
+func (sc *splitConn) Close() error {
+	if sc.replica != nil {
+		sc.replica.Close()
+	}
+	for cn := range sc.retired {
+		cn.Close()
+	}
+	return sc.primary.Close()
+}
+
+func (sc *splitConn) IsValid() bool {
+	return sc.primary.IsValid()
+}
+
+// splitStmt is a statement prepared on a replica, which is kept open until
+// the statement is closed. database/sql reuses it in transactions on the
+// same connection (sql.Tx.Stmt), which run elsewhere, so there it is
+// prepared again on the connection of the transaction.
+type splitStmt struct {
+	driver.Stmt
+	sc    *splitConn
+	cn    *conn
+	query string
+	tx    *stmt // prepared on sc.tx
+}
+
+// stmt returns the statement to execute on the current connection.
+func (st *splitStmt) stmt() (driver.Stmt, error) {
+	tx := st.sc.tx
+	if tx == nil || tx == st.cn {
+		return st.Stmt, nil
+	}
+	if st.tx != nil && st.tx.cn != tx {
+		st.tx.Close()
+		st.tx = nil
+	}
+	if st.tx == nil {
+		s, err := tx.prepare(st.query)
+		if err != nil {
+			return nil, err
+		}
+		st.tx = s
+	}
+	return st.tx, nil
+}
+
+func (st *splitStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s, err := st.stmt()
+	if err != nil {
+		return nil, err
+	}
+	return s.Exec(args)
+}
+
+func (st *splitStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s, err := st.stmt()
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(args)
+}
+
+func (st *splitStmt) Close() error {
+	if st.tx != nil {
+		st.tx.Close()
+	}
+	err := st.Stmt.Close()
+	sc := st.sc
+	if sc.stmts[st.cn]--; sc.stmts[st.cn] == 0 {
+		delete(sc.stmts, st.cn)
+		if sc.retired[st.cn] {
+			delete(sc.retired, st.cn)
+			st.cn.Close()
+		}
+	}
+	return err
+}
+
+type splitTx struct {
+	sc *splitConn
+	tx driver.Tx
+}
+
+func (t *splitTx) Commit() error {
+	t.sc.tx = nil
+	return t.tx.Commit()
+}
+
+func (t *splitTx) Rollback() error {
+	t.sc.tx = nil
+	return t.tx.Rollback()
+}
@@ -45,6 +45,9 @@ var benchmarks = []testing.InternalBenchmark{
 	{"BenchmarkStmt", BenchmarkStmt},
 	{"BenchmarkRows", BenchmarkRows},
 	{"BenchmarkStmtRows", BenchmarkStmtRows},
+	{"BenchmarkRawRows", BenchmarkRawRows},
+	{"BenchmarkPrepare", BenchmarkPrepare},
+	{"BenchmarkBlobParams", BenchmarkBlobParams},
 }
 
 // RunTests runs the SQL test suite
@@ -410,3 +413,57 @@ func BenchmarkStmtRows(b *testing.B) {
 		}
 	}
 }
+
+func BenchmarkRawRows(b *testing.B) {
+	db.once.Do(makeBench)
+
+	for n := 0; n < b.N; n++ {
+		var n, i, f, s, t sql.RawBytes
+		r, err := db.Query("select * from bench")
+		if err != nil {
+			panic(err)
+		}
+		for r.Next() {
+			if err = r.Scan(&n, &i, &f, &s, &t); err != nil {
+				panic(err)
+			}
+		}
+		if err = r.Err(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkPrepare(b *testing.B) {
+	for n := 0; n < b.N; n++ {
+		st, err := db.Prepare(db.q("select ?"))
+		if err != nil {
+			panic(err)
+		}
+		var i int
+		if err = st.QueryRow(n).Scan(&i); err != nil {
+			panic(err)
+		}
+		if err = st.Close(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func BenchmarkBlobParams(b *testing.B) {
+	blob := make([]byte, 64<<10)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+	b.SetBytes(int64(len(blob)))
+
+	for n := 0; n < b.N; n++ {
+		var v []byte
+		if err := db.QueryRow(db.q("select ?"), blob).Scan(&v); err != nil {
+			panic(err)
+		}
+		if len(v) != len(blob) {
+			panic(fmt.Sprintf("got %d bytes, want %d", len(v), len(blob)))
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package mysql
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sync"
+)
+
+// SSL modes, with the semantics of the mysql client's --ssl-mode option.
+const (
+	SSLModeDisabled       = "disabled"        // never use TLS
+	SSLModePreferred      = "preferred"       // use TLS if the server supports it
+	SSLModeRequired       = "required"        // require TLS, do not verify the server certificate
+	SSLModeVerifyCA       = "verify-ca"       // require TLS and verify the certificate chain
+	SSLModeVerifyIdentity = "verify-identity" // also verify the certificate matches the host
+)
+
+var (
+	tlsConfigsLock sync.RWMutex
+	tlsConfigs     = map[string]*tls.Config{}
+)
+
+// RegisterTLSConfig registers a TLS configuration that is selected in the
+// DSN with ssl-config=name. The configuration is cloned, so later changes
+// to config have no effect. Passing a nil config removes the registration.
+func RegisterTLSConfig(name string, config *tls.Config) {
+	tlsConfigsLock.Lock()
+	defer tlsConfigsLock.Unlock()
+	if config == nil {
+		delete(tlsConfigs, name)
+	} else {
+		tlsConfigs[name] = config.Clone()
+	}
+}
+
+func getTLSConfig(name string) *tls.Config {
+	tlsConfigsLock.RLock()
+	defer tlsConfigsLock.RUnlock()
+	if config, ok := tlsConfigs[name]; ok {
+		return config.Clone()
+	}
+	return nil
+}
+
+// sslParams are the ssl-* DSN parameters, which are resolved into a
+// tls.Config once the whole DSN has been parsed.
+type sslParams struct {
+	config     string
+	mode       string
+	ca         string
+	cert       string
+	key        string
+	skipVerify bool
+}
+
+func (s *sslParams) tlsConfig(cfg *Config) error {
+	if cfg.TLS == nil && s.config == "" && s.mode == "" && s.ca == "" && s.cert == "" && s.key == "" {
+		return nil
+	}
+
+	if s.config != "" {
+		if cfg.TLS = getTLSConfig(s.config); cfg.TLS == nil {
+			return fmt.Errorf("invalid ssl-config: %s", s.config)
+		}
+	} else if cfg.TLS == nil {
+		cfg.TLS = &tls.Config{}
+	}
+
+	if s.skipVerify && (s.mode == SSLModeVerifyCA || s.mode == SSLModeVerifyIdentity) {
+		return fmt.Errorf("ssl-insecure-skip-verify contradicts ssl-mode=%s", s.mode)
+	}
+	switch s.mode {
+	case "":
+		switch {
+		case s.skipVerify:
+			cfg.SSLMode = SSLModeRequired
+		case s.ca != "":
+			cfg.SSLMode = SSLModeVerifyCA
+		case s.config == "":
+			cfg.SSLMode = SSLModeVerifyIdentity
+		}
+	case SSLModeDisabled:
+		cfg.TLS, cfg.SSLMode = nil, SSLModeDisabled
+		return nil
+	case SSLModeRequired:
+		cfg.SSLMode = s.mode
+		if s.ca != "" && !s.skipVerify {
+			cfg.SSLMode = SSLModeVerifyCA // as the mysql client does
+		}
+	case SSLModePreferred, SSLModeVerifyCA, SSLModeVerifyIdentity:
+		cfg.SSLMode = s.mode
+	default:
+		return fmt.Errorf("invalid ssl-mode: %s", s.mode)
+	}
+
+	if s.ca != "" {
+		pem, err := ioutil.ReadFile(s.ca)
+		if err != nil {
+			return err
+		}
+		if cfg.TLS.RootCAs == nil {
+			cfg.TLS.RootCAs = x509.NewCertPool()
+		}
+		if !cfg.TLS.RootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("invalid ssl-ca: no certificates in %s", s.ca)
+		}
+	}
+
+	if s.cert != "" || s.key != "" {
+		if s.cert == "" || s.key == "" {
+			return fmt.Errorf("ssl-cert and ssl-key must be given together")
+		}
+		cert, err := tls.LoadX509KeyPair(s.cert, s.key)
+		if err != nil {
+			return err
+		}
+		cfg.TLS.Certificates = append(cfg.TLS.Certificates, cert)
+	}
+
+	switch cfg.SSLMode {
+	case SSLModePreferred:
+		cfg.TLS.InsecureSkipVerify = true
+		if s.ca != "" && !s.skipVerify {
+			cfg.TLS.VerifyPeerCertificate = verifyChain(cfg.TLS.RootCAs)
+		}
+	case SSLModeRequired:
+		cfg.TLS.InsecureSkipVerify = true
+	case SSLModeVerifyCA:
+		cfg.TLS.InsecureSkipVerify = true
+		cfg.TLS.VerifyPeerCertificate = verifyChain(cfg.TLS.RootCAs)
+	case SSLModeVerifyIdentity:
+		cfg.TLS.InsecureSkipVerify = false
+	}
+	return nil
+}
+
+// TLSConnectionState returns the state of the TLS connection, if the
+// connection is encrypted. It is reachable through sql.Conn.Raw.
+func (cn *conn) TLSConnectionState() (state tls.ConnectionState, ok bool) {
+	if c, ok := cn.netconn.(*tls.Conn); ok {
+		return c.ConnectionState(), true
+	}
+	return state, false
+}
+
+// verifyChain verifies the server certificate chain against roots without
+// checking the host name, which crypto/tls only does as part of the full
+// verification.
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server sent no certificate")
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				leaf = cert
+			} else {
+				opts.Intermediates.AddCert(cert)
+			}
+		}
+		_, err := leaf.Verify(opts)
+		return err
+	}
+}
+
+// hostname returns the host part of addr, used as the TLS server name.
+func hostname(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,371 @@
+package mysql
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NullDuration is a TIME value that may be NULL. It implements sql.Scanner
+// and driver.Valuer like sql.NullTime. NULL is only distinguishable from
+// zero with null-time in the DSN.
+type NullDuration struct {
+	Duration time.Duration
+	Valid    bool // Valid is true if Duration is not NULL
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *NullDuration) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		d.Duration, d.Valid = 0, false
+	case time.Duration:
+		d.Duration, d.Valid = v, true
+	case int64:
+		d.Duration, d.Valid = time.Duration(v), true
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		t := strings.Split(v, ":")
+		if len(t) != 3 {
+			return fmt.Errorf("invalid time: %s", v)
+		}
+		var err error
+		if d.Duration, err = time.ParseDuration(fmt.Sprintf("%sh%sm%ss", t[0], t[1], t[2])); err != nil {
+			return err
+		}
+		d.Valid = true
+	default:
+		return fmt.Errorf("cannot scan %T into NullDuration", value)
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface. The conn accepts the
+// time.Duration it returns as a TIME argument.
+func (d NullDuration) Value() (driver.Value, error) {
+	if !d.Valid {
+		return nil, nil
+	}
+	return d.Duration, nil
+}
+
+// Decimal is an exact DECIMAL value. It implements sql.Scanner and
+// driver.Valuer; a nil Rat is NULL. Scale is the number of digits after the
+// decimal point the value is formatted with, as scanned from the column.
+type Decimal struct {
+	Rat   *big.Rat
+	Scale int
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *Decimal) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		d.Rat, d.Scale = nil, 0
+	case []byte:
+		return d.Scan(string(v))
+	case string:
+		r, ok := new(big.Rat).SetString(v)
+		if !ok {
+			return fmt.Errorf("invalid decimal: %s", v)
+		}
+		d.Rat, d.Scale = r, 0
+		if i := strings.IndexByte(v, '.'); i >= 0 {
+			d.Scale = len(v) - i - 1
+		}
+	case int64:
+		d.Rat, d.Scale = new(big.Rat).SetInt64(v), 0
+	case float64:
+		return d.Scan(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		return fmt.Errorf("cannot scan %T into Decimal", value)
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface. It is an error if d has no
+// finite decimal representation.
+func (d Decimal) Value() (driver.Value, error) {
+	if d.Rat == nil {
+		return nil, nil
+	}
+	return decimalString(d)
+}
+
+// String formats d with at least Scale digits after the decimal point, and
+// more if needed to show it exactly. A value with no finite decimal
+// representation, such as 1/3, is rounded to Scale digits.
+func (d Decimal) String() string {
+	if d.Rat == nil {
+		return "NULL"
+	}
+	digits, ok := ratDigits(d.Rat)
+	if !ok || digits < d.Scale {
+		digits = d.Scale
+	}
+	return d.Rat.FloatString(digits)
+}
+
+// Float returns d as a big.Float, rounded to prec bits.
+func (d Decimal) Float(prec uint) *big.Float {
+	if d.Rat == nil {
+		return nil
+	}
+	return new(big.Float).SetPrec(prec).SetRat(d.Rat)
+}
+
+// ratDigits returns the number of digits after the decimal point needed to
+// write r exactly, or false if r has no finite decimal representation.
+func ratDigits(r *big.Rat) (int, bool) {
+	// A fraction is a finite decimal if its denominator has no prime
+	// factors other than 2 and 5, and needs as many digits as the larger
+	// power of them.
+	den, digits := new(big.Int).Set(r.Denom()), 0
+	for _, f := range []*big.Int{big.NewInt(2), big.NewInt(5)} {
+		n := 0
+		for q, m := new(big.Int), new(big.Int); ; n++ {
+			if q.QuoRem(den, f, m); m.Sign() != 0 {
+				break
+			}
+			den.Set(q)
+		}
+		if n > digits {
+			digits = n
+		}
+	}
+	return digits, den.Cmp(big.NewInt(1)) == 0
+}
+
+// decimalString formats a *big.Rat, *big.Float or Decimal argument exactly.
+// A Decimal is written with at least Scale digits after the decimal point.
+func decimalString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case *big.Rat:
+		if v == nil {
+			break
+		}
+		digits, ok := ratDigits(v)
+		if !ok {
+			return "", fmt.Errorf("%s is not a finite decimal", v.RatString())
+		}
+		return v.FloatString(digits), nil
+	case *big.Float:
+		if v == nil || v.IsInf() {
+			break
+		}
+		return v.Text('f', -1), nil
+	case Decimal:
+		if v.Rat == nil {
+			break
+		}
+		digits, ok := ratDigits(v.Rat)
+		if !ok {
+			return "", fmt.Errorf("%s is not a finite decimal", v.Rat.RatString())
+		}
+		if digits < v.Scale {
+			digits = v.Scale
+		}
+		return v.Rat.FloatString(digits), nil
+	}
+	return "", fmt.Errorf("invalid decimal: %v", v)
+}
+
+// JSON is the raw value of a JSON column. It implements sql.Scanner and
+// driver.Valuer; nil is NULL.
+type JSON json.RawMessage
+
+// Scan implements the sql.Scanner interface.
+func (j *JSON) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*j = nil
+	case []byte:
+		*j = append(JSON(nil), v...)
+	case string:
+		*j = append(JSON(nil), v...)
+	default:
+		return fmt.Errorf("cannot scan %T into JSON", value)
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (j JSON) Value() (driver.Value, error) {
+	if j == nil {
+		return nil, nil
+	}
+	return string(j), nil
+}
+
+// MarshalJSON returns j, so that JSON can be embedded in other values.
+func (j JSON) MarshalJSON() ([]byte, error) {
+	return json.RawMessage(j).MarshalJSON()
+}
+
+// UnmarshalJSON sets j to a copy of data.
+func (j *JSON) UnmarshalJSON(data []byte) error {
+	return (*json.RawMessage)(j).UnmarshalJSON(data)
+}
+
+// JSONValue marshals V to JSON when used as an argument and unmarshals a
+// JSON column into V, which must then be a pointer, when scanned:
+//
+//	var attrs map[string]string
+//	err := row.Scan(&mysql.JSONValue{V: &attrs})
+//
+// NULL leaves V unchanged.
+type JSONValue struct {
+	V interface{}
+}
+
+// Scan implements the sql.Scanner interface.
+func (j *JSONValue) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, j.V)
+	case string:
+		return json.Unmarshal([]byte(v), j.V)
+	}
+	return fmt.Errorf("cannot scan %T into JSONValue", value)
+}
+
+// Value implements the driver.Valuer interface.
+func (j JSONValue) Value() (driver.Value, error) {
+	b, err := json.Marshal(j.V)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Set is the value of a SET column. It implements sql.Scanner and
+// driver.Valuer; NULL scans as nil and a nil Set is NULL.
+type Set []string
+
+// Scan implements the sql.Scanner interface.
+func (s *Set) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+	case []byte:
+		return s.Scan(string(v))
+	case string:
+		if v == "" {
+			*s = Set{}
+		} else {
+			*s = strings.Split(v, ",")
+		}
+	default:
+		return fmt.Errorf("cannot scan %T into Set", value)
+	}
+	return nil
+}
+
+// Value implements the driver.Valuer interface.
+func (s Set) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	for _, m := range s {
+		if strings.Contains(m, ",") {
+			return nil, fmt.Errorf("invalid set member: %s", m)
+		}
+	}
+	return strings.Join(s, ","), nil
+}
+
+// Vector is the value of a VECTOR column. It implements sql.Scanner and
+// driver.Valuer; NULL scans as nil.
+type Vector []float32
+
+// ParseVector parses a vector in the format of STRING_TO_VECTOR, such as
+// "[1.5,2,3e-2]".
+func ParseVector(s string) (Vector, error) {
+	s = strings.TrimSpace(s)
+	if len(s) < 2 || s[0] != '[' || s[len(s)-1] != ']' {
+		return nil, fmt.Errorf("invalid vector: %s", s)
+	}
+	v := Vector{}
+	if strings.TrimSpace(s[1:len(s)-1]) == "" {
+		return v, nil
+	}
+	for _, e := range strings.Split(s[1:len(s)-1], ",") {
+		f, err := strconv.ParseFloat(strings.TrimSpace(e), 32)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("invalid vector: %s", s)
+		}
+		v = append(v, float32(f))
+	}
+	return v, nil
+}
+
+// String formats v like VECTOR_TO_STRING, in a form accepted by
+// STRING_TO_VECTOR.
+func (v Vector) String() string {
+	b := []byte{'['}
+	for i, f := range v {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = strconv.AppendFloat(b, float64(f), 'e', 5, 32)
+	}
+	return string(append(b, ']'))
+}
+
+// Scan implements the sql.Scanner interface.
+func (v *Vector) Scan(value interface{}) (err error) {
+	switch x := value.(type) {
+	case nil:
+		*v = nil
+	case []float32:
+		*v = append(Vector{}, x...)
+	case []byte:
+		var f []float32
+		f, err = vectorValue(x)
+		*v = f
+	case string:
+		*v, err = ParseVector(x)
+	default:
+		return fmt.Errorf("cannot scan %T into Vector", value)
+	}
+	return err
+}
+
+// Value implements the driver.Valuer interface.
+func (v Vector) Value() (driver.Value, error) {
+	if v == nil {
+		return nil, nil
+	}
+	return v.bytes(), nil
+}
+
+// bytes returns v in the binary format of VECTOR columns: little-endian
+// float32 values.
+func (v Vector) bytes() []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+	return b
+}
+
+// vectorValue decodes the value of a VECTOR column.
+func vectorValue(b []byte) ([]float32, error) {
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("invalid vector length: %d", len(b))
+	}
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return v, nil
+}